@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// AuditVersion is the version of the audit record schema, which only
+	// changes on incompatible changes to it.
+	AuditVersion = 1
+
+	// AuditActionScanRequested is the action recorded for each scan request.
+	AuditActionScanRequested = "ScanRequested"
+)
+
+// auditLog is where every registry records its scan requests, if anywhere.
+var auditLog *AuditLog
+
+// AuditRecord is a single line of the audit log.
+type AuditRecord struct {
+	Version     int       `json:"version"`
+	Time        time.Time `json:"time"`
+	Actor       string    `json:"actor"`
+	Action      string    `json:"action"`
+	Region      string    `json:"region"`
+	RegistryID  string    `json:"registryId,omitempty"`
+	Repository  string    `json:"repository"`
+	ImageDigest string    `json:"imageDigest"`
+	ImageTag    string    `json:"imageTag"`
+}
+
+// AuditLog writes an audit record of each scan request as a line of JSON,
+// separately from our logs so that it can be shipped to an audit pipeline.
+type AuditLog struct {
+	Actor   string
+	Metrics *Metrics
+
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+// SetupAuditLog opens the configured audit log, if enabled, for every registry
+// to record its scan requests in. Records go to standard output unless a file
+// is configured, which is appended to.
+func SetupAuditLog(metrics *Metrics) error {
+	if !viper.GetBool("audit.enabled") {
+		return nil
+	}
+
+	// Without an actor configured, identify ourselves by our hostname, which
+	// is the pod name in Kubernetes.
+	actor := viper.GetString("audit.actor")
+	if actor == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		actor = hostname
+	}
+
+	var writer io.Writer = os.Stdout
+	if path := viper.GetString("audit.file"); path != "" {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		OnExit(func() {
+			if err := file.Close(); err != nil {
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Warn("failed to close audit log")
+			}
+		})
+		writer = file
+	}
+
+	auditLog = &AuditLog{Actor: actor, Metrics: metrics, writer: writer}
+	return nil
+}
+
+// Notify records the scan requests among the notifications. Failures are
+// logged and counted but never returned, as auditing must not abort
+// reconciliation.
+func (a *AuditLog) Notify(ctx context.Context, notification ScanNotification) {
+	if notification.Event != EventScanRequested {
+		return
+	}
+
+	line, err := json.Marshal(AuditRecord{
+		Version:     AuditVersion,
+		Time:        time.Now().UTC(),
+		Actor:       a.Actor,
+		Action:      AuditActionScanRequested,
+		Region:      notification.Region,
+		RegistryID:  notification.RegistryID,
+		Repository:  notification.Repository,
+		ImageDigest: notification.ImageDigest,
+		ImageTag:    notification.ImageTag,
+	})
+	if err == nil {
+		a.mutex.Lock()
+		_, err = a.writer.Write(append(line, '\n'))
+		a.mutex.Unlock()
+	}
+	if err != nil {
+		a.Metrics.AuditWriteErrors.Inc()
+		log.WithFields(log.Fields{
+			"err":        err,
+			"repository": notification.Repository,
+		}).Error("failed to write audit record")
+	}
+}
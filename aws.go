@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go/middleware"
+
+	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-sdk-go-v2/otelaws"
+)
+
+// LoadAWSConfig resolves the AWS configuration from the default chain and, if a
+// role ARN is configured, wraps the credentials so that the role is assumed.
+// Any given options take precedence over those we configure.
+func LoadAWSConfig(
+	ctx context.Context,
+	opts ...func(*config.LoadOptions) error,
+) (aws.Config, error) {
+	// Bound the SDK's own retries and requests, each of which happen within a
+	// single attempt of our own retries.
+	var defaults []func(*config.LoadOptions) error
+	if attempts := viper.GetInt("aws.max_retries"); attempts > 0 {
+		defaults = append(defaults, config.WithRetryMaxAttempts(attempts))
+	}
+	if timeout := viper.GetDuration("aws.http_timeout"); timeout > 0 {
+		defaults = append(defaults, config.WithHTTPClient(
+			awshttp.NewBuildableClient().WithTimeout(timeout),
+		))
+	}
+
+	// Read the shared configuration and credentials from the given files
+	// rather than those in ~/.aws, if any.
+	if files := viper.GetStringSlice("aws.config_files"); len(files) > 0 {
+		defaults = append(defaults, config.WithSharedConfigFiles(files))
+	}
+	if files := viper.GetStringSlice("aws.credentials_files"); len(files) > 0 {
+		defaults = append(defaults, config.WithSharedCredentialsFiles(files))
+	}
+
+	// Send every AWS API call to a single custom endpoint if given one, such as
+	// LocalStack when testing.
+	if endpoint := viper.GetString("aws.endpoint_url"); endpoint != "" {
+		defaults = append(defaults, config.WithEndpointResolverWithOptions(
+			aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+				return aws.Endpoint{
+					HostnameImmutable: true,
+					SigningRegion:     region,
+					Source:            aws.EndpointSourceCustom,
+					URL:               endpoint,
+				}, nil
+			}),
+		))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, append(defaults, opts...)...)
+	if err != nil {
+		return cfg, err
+	}
+
+	// Trace each AWS API call, which does nothing unless tracing is set up.
+	otelaws.AppendMiddlewares(&cfg.APIOptions)
+
+	// If no role is configured we use the default credentials as-is.
+	arn := viper.GetString("aws.assume_role_arn")
+	if arn == "" {
+		return cfg, nil
+	}
+
+	log.WithFields(log.Fields{
+		"role": arn,
+	}).Debug("assuming AWS IAM role")
+	provider := stscreds.NewAssumeRoleProvider(
+		sts.NewFromConfig(cfg),
+		arn,
+		func(o *stscreds.AssumeRoleOptions) {
+			if id := viper.GetString("aws.external_id"); id != "" {
+				o.ExternalID = aws.String(id)
+			}
+		},
+	)
+	cfg.Credentials = aws.NewCredentialsCache(provider)
+	return cfg, nil
+}
+
+// LoadStartupAWSConfig loads the AWS configuration much like LoadAWSConfig, but
+// retries with exponential backoff until its credentials can be retrieved, as
+// the credential providers may be briefly overwhelmed while many replicas start
+// at once.
+func LoadStartupAWSConfig(
+	ctx context.Context,
+	opts ...func(*config.LoadOptions) error,
+) (aws.Config, error) {
+	attempts := viper.GetInt("startup.retry.max_attempts")
+	delay := viper.GetDuration("startup.retry.base_delay")
+	for attempt := 1; ; attempt++ {
+		cfg, err := LoadAWSConfig(ctx, opts...)
+		if err == nil && cfg.Credentials != nil {
+			_, err = cfg.Credentials.Retrieve(ctx)
+		}
+		if err == nil || attempt >= attempts {
+			return cfg, err
+		}
+
+		log.WithFields(log.Fields{
+			"attempt": attempt,
+			"delay":   delay,
+			"err":     err,
+		}).Warn("failed to load AWS configuration, retrying")
+		select {
+		case <-ctx.Done():
+			return cfg, err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// Regions returns the regions we're reconciling, with an empty region standing
+// for the region resolved by the default AWS configuration chain.
+func Regions() []string {
+	regions := viper.GetStringSlice("aws.regions")
+	if len(regions) == 0 {
+		return []string{""}
+	}
+	return regions
+}
+
+// Profiles returns the named AWS profiles from the shared configuration files
+// we're reconciling the registries of, with an empty profile standing for the
+// profile resolved by the default AWS configuration chain.
+func Profiles() []string {
+	profiles := viper.GetStringSlice("aws.profiles")
+	if len(profiles) == 0 {
+		return []string{""}
+	}
+	return profiles
+}
+
+// AWSConfigOptions returns the options loading the AWS configuration of the
+// given profile and region, either of which may be empty to leave it to the
+// default AWS configuration chain.
+func AWSConfigOptions(profile string, region string) []func(*config.LoadOptions) error {
+	var opts []func(*config.LoadOptions) error
+	if profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(profile))
+	}
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	return opts
+}
+
+// Preflight checks that the credentials of the AWS configuration work,
+// returning the ARN they identify as. Unless told otherwise it also checks that
+// AWS ECR lets us list the repositories we're going to scan, using the cheapest
+// request that needs the same permissions as our runs.
+func Preflight(ctx context.Context, cfg aws.Config, repositories bool) (string, error) {
+	identity, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("failed to identify AWS caller, check the credentials: %w", err)
+	}
+	caller := aws.ToString(identity.Arn)
+	if !repositories {
+		return caller, nil
+	}
+
+	client := ecr.NewFromConfig(cfg)
+	var id *string
+	if registry := viper.GetString("aws.registry_id"); registry != "" {
+		id = aws.String(registry)
+	}
+
+	// Named repositories are never described, so check we can list one of them
+	// instead.
+	if names := viper.GetStringSlice("repositories.names"); len(names) > 0 {
+		_, err = client.ListImages(ctx, &ecr.ListImagesInput{
+			MaxResults:     aws.Int32(1),
+			RegistryId:     id,
+			RepositoryName: aws.String(names[0]),
+		})
+		if err != nil {
+			return caller, fmt.Errorf("failed to list images of repository %q, check the permissions of %s: %w", names[0], caller, err)
+		}
+		return caller, nil
+	}
+
+	_, err = client.DescribeRepositories(ctx, &ecr.DescribeRepositoriesInput{
+		MaxResults: aws.Int32(1),
+		RegistryId: id,
+	})
+	if err != nil {
+		return caller, fmt.Errorf("failed to describe repositories, check the permissions of %s: %w", caller, err)
+	}
+	return caller, nil
+}
+
+// RecordRequestDuration returns an option adding a middleware to the stack of an
+// AWS ECR client observing the duration of each request. It runs before the AWS
+// SDK's own retries, which happen in a later step, so the duration covers every
+// attempt of the request.
+func RecordRequestDuration(metrics *Metrics) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc(
+			"RecordRequestDuration",
+			func(
+				ctx context.Context,
+				in middleware.InitializeInput,
+				next middleware.InitializeHandler,
+			) (middleware.InitializeOutput, middleware.Metadata, error) {
+				start := time.Now()
+				out, metadata, err := next.HandleInitialize(ctx, in)
+				metrics.APIRequestDuration.WithLabelValues(
+					awsmiddleware.GetOperationName(ctx),
+				).Observe(time.Since(start).Seconds())
+				return out, metadata, err
+			},
+		), middleware.After)
+	}
+}
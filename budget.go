@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+var (
+	resumeMutex sync.Mutex
+	resumeFrom  = map[string]string{}
+)
+
+// ScanBudget caps the number of scans requested throughout a single run. It's
+// shared by every image of the run, so it must be safe for concurrent use.
+type ScanBudget struct {
+	remaining int64
+
+	mutex  sync.Mutex
+	denied map[string]string
+}
+
+// NewScanBudget creates a budget of the given number of scans, or nil if the
+// number of scans is unlimited.
+func NewScanBudget(limit int64) *ScanBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &ScanBudget{remaining: limit, denied: map[string]string{}}
+}
+
+// Take spends a scan of the budget on an image of the repository, reporting
+// whether there was any left. A nil budget is never exhausted.
+func (b *ScanBudget) Take(registry Registry, repository types.Repository) bool {
+	if b == nil {
+		return true
+	}
+	if atomic.AddInt64(&b.remaining, -1) >= 0 {
+		return true
+	}
+
+	// Remember the first repository we turned away in each registry, so that
+	// the next run can start there.
+	b.mutex.Lock()
+	name := RegistryName(registry)
+	if _, ok := b.denied[name]; !ok {
+		b.denied[name] = *repository.RepositoryName
+	}
+	b.mutex.Unlock()
+	registry.Metrics.ScansBudgetExceeded.WithLabelValues(registry.Region).Inc()
+	return false
+}
+
+// Remember records where the next run should resume from in each registry, so
+// that every repository eventually gets its turn however small the budget.
+// Registries whose repositories all fit within the budget start from the
+// beginning again.
+func (b *ScanBudget) Remember() {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	resumeMutex.Lock()
+	defer resumeMutex.Unlock()
+	resumeFrom = map[string]string{}
+	for name, repository := range b.denied {
+		resumeFrom[name] = repository
+	}
+}
+
+// ResumeRepositories rotates the repositories of the named registry to start
+// from the repository the previous run exhausted its budget on, if any.
+func ResumeRepositories(registry string, repositories []types.Repository) []types.Repository {
+	resumeMutex.Lock()
+	name, ok := resumeFrom[registry]
+	resumeMutex.Unlock()
+	if !ok {
+		return repositories
+	}
+
+	for i, repository := range repositories {
+		if *repository.RepositoryName == name {
+			rotated := make([]types.Repository, 0, len(repositories))
+			rotated = append(rotated, repositories[i:]...)
+			return append(rotated, repositories[:i]...)
+		}
+	}
+	return repositories
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// These are injected at build time through the linker, for example with
+// `-ldflags "-X main.Version=v1.2.3 -X main.Commit=abc1234"`.
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+// BuildCommit returns the commit the operator was built from, falling back to
+// the VCS revision Go records in the binary when none was injected.
+func BuildCommit() string {
+	if Commit != "" {
+		return Commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return "unknown"
+}
+
+// RecordBuildInfo exports the build of the operator as a metric, returning the
+// same as labels for our logs.
+func RecordBuildInfo(metrics *Metrics) map[string]string {
+	labels := map[string]string{
+		"version":   Version,
+		"commit":    BuildCommit(),
+		"goversion": runtime.Version(),
+	}
+	metrics.BuildInfo.With(labels).Set(1)
+	return labels
+}
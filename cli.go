@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// CommandServe runs the operator as a daemon, scanning on its schedule and
+	// serving its webserver until it's asked to shut down.
+	CommandServe = "serve"
+
+	// CommandScan runs a single scan synchronously and exits.
+	CommandScan = "scan"
+
+	// CommandValidate validates the configuration and exits without touching
+	// AWS at all.
+	CommandValidate = "validate"
+)
+
+// NewCommand creates the command line of the operator, which serves unless
+// told to run one of its other commands.
+func NewCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "aws-ecr-scan-operator",
+		Short: "Request scans of AWS ECR images on a schedule",
+		Long: "Requests scans of the images in AWS ECR repositories on a schedule, " +
+			"exporting the results as Prometheus metrics.",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			BindFlags(cmd.Flags())
+			Operate(CommandServe)
+		},
+	}
+	root.PersistentFlags().String("config", "", "path to a YAML or JSON configuration file")
+
+	// These predate our commands and are kept working for existing setups.
+	root.Flags().Bool("once", false, "run a single scan synchronously and exit")
+	root.Flags().String("repository", "", "scan only the given images of this repository and exit")
+	root.Flags().StringSlice("image", nil, "a digest or tag of an image of the repository to scan, may be repeated")
+	_ = root.Flags().MarkDeprecated("once", "use the scan command instead")
+	_ = root.Flags().MarkDeprecated("repository", "use the scan command instead")
+	_ = root.Flags().MarkDeprecated("image", "use the scan command instead")
+
+	serve := &cobra.Command{
+		Use:   CommandServe,
+		Short: "Scan on a schedule until asked to shut down, the default",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			BindFlags(cmd.Flags())
+			Operate(CommandServe)
+		},
+	}
+
+	scan := &cobra.Command{
+		Use:   CommandScan,
+		Short: "Run a single scan synchronously and exit",
+		Long: "Runs a single scan synchronously and exits, with a non-zero exit code " +
+			"if it fails. Given a repository and its images, only those images are scanned.",
+		Example: "  aws-ecr-scan-operator scan\n" +
+			"  aws-ecr-scan-operator scan --repository my-repository --image sha256:... --image latest",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			BindFlags(cmd.Flags())
+			viper.Set("once", true)
+			Operate(CommandScan)
+		},
+	}
+	scan.Flags().String("repository", "", "scan only the given images of this repository")
+	scan.Flags().StringSlice("image", nil, "a digest or tag of an image of the repository to scan, may be repeated")
+
+	validate := &cobra.Command{
+		Use:   CommandValidate,
+		Short: "Validate the configuration and exit",
+		Long: "Validates the configuration as on startup, exiting with the same exit " +
+			"codes if it's invalid, without loading any AWS configuration or credentials.",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			BindFlags(cmd.Flags())
+			Operate(CommandValidate)
+		},
+	}
+
+	version := &cobra.Command{
+		Use:   "version",
+		Short: "Print the build of the operator and exit",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Fprintf(cmd.OutOrStdout(), "aws-ecr-scan-operator %s (commit %s, %s)\n", Version, BuildCommit(), runtime.Version())
+		},
+	}
+
+	root.AddCommand(serve, scan, validate, version)
+	return root
+}
+
+// BindFlags binds the flags of the command being run to our configuration.
+func BindFlags(flags *pflag.FlagSet) {
+	if err := viper.BindPFlags(flags); err != nil {
+		Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+			"err": err,
+		}), "failed to bind command-line flags")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// scanQuotaWindow is how long AWS ECR takes before it allows another scan of
+// an image.
+const scanQuotaWindow = 24 * time.Hour
+
+// DeferredScans retries the scans of rate-limited images once they're about to
+// be eligible again, rather than leaving them to the next run. Each image is
+// only retried once, and only while we remain the leader.
+type DeferredScans struct {
+	// Pool is the worker pool the retries are funneled through.
+	Pool *WorkerPool
+
+	// Leader tracks whether we're still the replica allowed to run scans.
+	Leader *Leader
+
+	// Max is the longest we're willing to wait before retrying a scan.
+	Max time.Duration
+
+	ctx     context.Context
+	jitter  *Jitter
+	mutex   sync.Mutex
+	pending map[string]*time.Timer
+}
+
+// NewDeferredScans creates the deferred scans, retried until the given context
+// is cancelled, or nil if scans are never deferred.
+func NewDeferredScans(
+	ctx context.Context,
+	pool *WorkerPool,
+	leader *Leader,
+	max time.Duration,
+) *DeferredScans {
+	if max <= 0 {
+		return nil
+	}
+	return &DeferredScans{
+		Pool:    pool,
+		Leader:  leader,
+		Max:     max,
+		ctx:     ctx,
+		jitter:  NewJitter(time.Minute, time.Now().UnixNano()),
+		pending: map[string]*time.Timer{},
+	}
+}
+
+// Defer schedules a retry of the rate-limited image's scan, reporting whether
+// it did. The retry happens once its last scan leaves the quota window if we
+// know when that was, or after the maximum delay otherwise, plus up to a
+// minute of jitter. Images that won't be eligible within the maximum delay are
+// left to the next run.
+func (d *DeferredScans) Defer(
+	ctx context.Context,
+	registry Registry,
+	repository types.Repository,
+	image types.ImageIdentifier,
+) bool {
+	// Setup our logging context for the function.
+	logger := registry.Logger.WithFields(log.Fields{
+		"image": map[string]string{
+			"digest": *image.ImageDigest,
+			"tag":    ImageTag(image),
+		},
+		"region":     registry.Region,
+		"repository": *repository.RepositoryName,
+	})
+
+	delay := d.Max
+	if last, ok := d.lastScan(ctx, registry, repository, image); ok {
+		delay = time.Until(last.Add(scanQuotaWindow))
+		if delay > d.Max {
+			logger.WithFields(log.Fields{
+				"delay": delay,
+			}).Debug("image not eligible for a scan soon enough, leaving it to the next run")
+			return false
+		}
+		if delay < 0 {
+			delay = 0
+		}
+	}
+	delay += d.jitter.Delay()
+
+	key := StateKey(registry, repository, image)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, ok := d.pending[key]; ok {
+		return true
+	}
+
+	// Retries are neither deferred again nor count against the budget of the
+	// run that deferred them.
+	retry := registry
+	retry.Budget = nil
+	retry.Deferred = nil
+	d.pending[key] = time.AfterFunc(delay, func() {
+		d.mutex.Lock()
+		delete(d.pending, key)
+		d.mutex.Unlock()
+		if d.ctx.Err() != nil || (d.Leader != nil && !d.Leader.IsLeader()) {
+			return
+		}
+		d.Pool.Submit(func() {
+			result := ReconcileImage(d.ctx, retry, repository, image)
+			logger.WithFields(result.Fields()).Info("deferred image scan retried")
+		})
+	})
+	registry.Metrics.ScansDeferred.WithLabelValues(registry.Region).Inc()
+	logger.WithFields(log.Fields{
+		"delay": delay,
+	}).Info("image scan rate-limited, deferring retry")
+	return true
+}
+
+// lastScan returns when the image was last scanned, from our state store if we
+// have one and from AWS ECR otherwise.
+func (d *DeferredScans) lastScan(
+	ctx context.Context,
+	registry Registry,
+	repository types.Repository,
+	image types.ImageIdentifier,
+) (time.Time, bool) {
+	if registry.State != nil {
+		last, ok, err := registry.State.LastScan(ctx, StateKey(registry, repository, image))
+		if err == nil && ok {
+			return last, true
+		}
+	}
+	details, err := FetchImageDetails(ctx, registry, repository, []types.ImageIdentifier{image})
+	if err != nil {
+		return time.Time{}, false
+	}
+	return LastScanTime(details[*image.ImageDigest])
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// digestFiles caches the digest lists read from files for every image checked
+// against them.
+var digestFiles = &DigestFiles{}
+
+// DigestFiles reads lists of image digests from files, only checking each
+// file once per run and rereading it once it changes so that edits to it,
+// such as to a mounted ConfigMap, take effect without a restart. It's safe for
+// concurrent use.
+type DigestFiles struct {
+	mutex      sync.Mutex
+	entries    map[string]digestFile
+	generation int
+}
+
+// digestFile is the last read of a digest list file.
+type digestFile struct {
+	modified time.Time
+	size     int64
+	digests  []string
+	failed   bool
+	read     bool
+	checked  int
+}
+
+// Refresh has each file checked for changes again the next time it's read,
+// which is done at the start of each run.
+func (f *DigestFiles) Refresh() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.generation++
+}
+
+// Read returns the digests listed in the file, either as a YAML list or one per
+// line, and whether the file was ever read. If the file can't be read, the
+// digests it last listed are returned, if any, and the failure is only logged
+// once until it's read again.
+func (f *DigestFiles) Read(file string) ([]string, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.entries == nil {
+		f.entries = map[string]digestFile{}
+	}
+	entry, ok := f.entries[file]
+	if ok && entry.checked == f.generation {
+		return entry.digests, entry.read
+	}
+	entry.checked = f.generation
+
+	info, err := os.Stat(file)
+	if err == nil && ok && !entry.failed && info.ModTime().Equal(entry.modified) && info.Size() == entry.size {
+		f.entries[file] = entry
+		return entry.digests, entry.read
+	}
+	var digests []string
+	if err == nil {
+		digests, err = EntriesFromFile(file)
+	}
+	if err != nil {
+		if !entry.failed {
+			log.WithFields(log.Fields{
+				"err":  err,
+				"file": file,
+			}).Warn("failed to read digest list file, keeping its previous digests")
+		}
+		entry.failed = true
+		f.entries[file] = entry
+		return entry.digests, entry.read
+	}
+
+	log.WithFields(log.Fields{
+		"digests": len(digests),
+		"file":    file,
+	}).Debug("read digest list file")
+	f.entries[file] = digestFile{
+		modified: info.ModTime(),
+		size:     info.Size(),
+		digests:  digests,
+		read:     true,
+		checked:  f.generation,
+	}
+	return digests, true
+}
+
+// MatchesDigest reports whether the digest matches any of the given digests or
+// digest prefixes, which may leave out the digest's algorithm.
+func MatchesDigest(digest string, prefixes []string) bool {
+	_, hex, _ := strings.Cut(digest, ":")
+	for _, prefix := range prefixes {
+		if prefix == "" {
+			continue
+		}
+		if strings.HasPrefix(digest, prefix) || (hex != "" && strings.HasPrefix(hex, prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// DigestList returns the digests configured under the key, along with those
+// listed in the file configured under the key suffixed with _file, and whether
+// that file, if any, was ever read.
+func DigestList(key string) ([]string, bool) {
+	digests := viper.GetStringSlice(key)
+	file := viper.GetString(key + "_file")
+	if file == "" {
+		return digests, true
+	}
+	listed, read := digestFiles.Read(file)
+	return append(append([]string(nil), digests...), listed...), read
+}
+
+// ShouldScanDigest determines whether the image with the given digest should
+// be scanned based on the configured digest denylist and allowlist. The
+// denylist always wins over the allowlist, and an empty allowlist allows every
+// digest, unless its file has never been read in which case it allows none.
+func ShouldScanDigest(digest string) bool {
+	if denylist, _ := DigestList("images.digest_denylist"); MatchesDigest(digest, denylist) {
+		return false
+	}
+
+	allowlist, read := DigestList("images.digest_allowlist")
+	if !read {
+		return false
+	}
+	if len(allowlist) == 0 {
+		return true
+	}
+	return MatchesDigest(digest, allowlist)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShouldScanDigestAllowlistFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "allowlist")
+	configure(t, map[string]interface{}{
+		"images.digest_allowlist":      []string{},
+		"images.digest_allowlist_file": file,
+		"images.digest_denylist":       []string{"sha256:bad"},
+	})
+	previous := digestFiles
+	digestFiles = &DigestFiles{}
+	t.Cleanup(func() { digestFiles = previous })
+
+	// An allowlist file that has never been read allows nothing.
+	if ShouldScanDigest("sha256:abc") {
+		t.Error("ShouldScanDigest() = true with a missing allowlist file, want false")
+	}
+
+	if err := os.WriteFile(file, []byte("- sha256:abc\n- def\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	digestFiles.Refresh()
+	tests := map[string]bool{
+		"sha256:abc": true,
+		"sha256:def": true,
+		"sha256:ghi": false,
+		"sha256:bad": false,
+	}
+	for digest, want := range tests {
+		if got := ShouldScanDigest(digest); got != want {
+			t.Errorf("ShouldScanDigest(%q) = %t, want %t", digest, got, want)
+		}
+	}
+
+	// Once read, the allowlist keeps applying when the file goes missing.
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	digestFiles.Refresh()
+	if !ShouldScanDigest("sha256:abc") || ShouldScanDigest("sha256:ghi") {
+		t.Error("ShouldScanDigest() dropped the previously read allowlist")
+	}
+}
+
+func TestDigestFilesReadOncePerRun(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "denylist")
+	if err := os.WriteFile(file, []byte("sha256:abc\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files := &DigestFiles{}
+	if digests, read := files.Read(file); !read || len(digests) != 1 {
+		t.Fatalf("Read() = %v, %t, want the listed digest", digests, read)
+	}
+
+	// Changes only take effect from the next run.
+	if err := os.WriteFile(file, []byte("sha256:abc\nsha256:def\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if digests, _ := files.Read(file); len(digests) != 1 {
+		t.Errorf("Read() = %v within the same run, want the digests read at its start", digests)
+	}
+	files.Refresh()
+	if digests, _ := files.Read(file); len(digests) != 2 {
+		t.Errorf("Read() = %v in the next run, want the changed digests", digests)
+	}
+}
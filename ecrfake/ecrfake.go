@@ -0,0 +1,303 @@
+// Package ecrfake provides an in-memory fake of the AWS ECR API surface used by
+// the operator, with canned repositories and images and injectable errors, so
+// that reconciliation can be exercised end-to-end without AWS or LocalStack.
+package ecrfake
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// Client is a fake AWS ECR client. Its responses are paginated by PageSize,
+// and every call is counted by operation so that errors can be injected into
+// specific calls. It's safe for concurrent use.
+type Client struct {
+	// Repositories are returned by DescribeRepositories.
+	Repositories []types.Repository
+
+	// Images are returned by ListImages, keyed by repository name.
+	Images map[string][]types.ImageIdentifier
+
+	// Details are returned by DescribeImages, keyed by image digest. Images
+	// described by tag alone are found by the tags of their details.
+	Details map[string]types.ImageDetail
+
+	// Findings are returned by DescribeImageScanFindings, keyed by image
+	// digest. Images without findings have never been scanned.
+	Findings map[string]types.ImageScanFindings
+
+	// Tags are returned by ListTagsForResource, keyed by repository ARN.
+	Tags map[string][]types.Tag
+
+	// Manifests are returned by BatchGetImage, keyed by image digest. Their
+	// media type is left for the operator to read from the manifest itself.
+	Manifests map[string]string
+
+	// ScanningConfiguration is returned by GetRegistryScanningConfiguration
+	// and replaced by PutRegistryScanningConfiguration.
+	ScanningConfiguration *types.RegistryScanningConfiguration
+
+	// PageSize is the number of items in each page of a paginated response,
+	// everything in a single page when zero.
+	PageSize int
+
+	mutex   sync.Mutex
+	calls   map[string]int
+	errors  map[string]map[int]error
+	scanned []types.ImageIdentifier
+}
+
+// InjectError makes the given call of an operation, counting from one, fail
+// with the error. A call of zero makes every call of the operation fail.
+func (c *Client) InjectError(operation string, call int, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.errors == nil {
+		c.errors = map[string]map[int]error{}
+	}
+	if c.errors[operation] == nil {
+		c.errors[operation] = map[int]error{}
+	}
+	c.errors[operation][call] = err
+}
+
+// Calls returns the number of calls made of an operation.
+func (c *Client) Calls(operation string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.calls[operation]
+}
+
+// Scanned returns the images whose scans were successfully requested, in the
+// order they were requested.
+func (c *Client) Scanned() []types.ImageIdentifier {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]types.ImageIdentifier(nil), c.scanned...)
+}
+
+// call counts a call of the operation, returning its injected error if any.
+func (c *Client) call(operation string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.calls == nil {
+		c.calls = map[string]int{}
+	}
+	c.calls[operation]++
+	if err, ok := c.errors[operation][c.calls[operation]]; ok {
+		return err
+	}
+	return c.errors[operation][0]
+}
+
+// page returns the bounds of the page starting at the token, along with the
+// token of the next page if there is one.
+func (c *Client) page(token *string, total int) (int, int, *string) {
+	start, _ := strconv.Atoi(aws.ToString(token))
+	if start > total {
+		start = total
+	}
+	end := total
+	if c.PageSize > 0 && start+c.PageSize < total {
+		end = start + c.PageSize
+	}
+	if end < total {
+		return start, end, aws.String(strconv.Itoa(end))
+	}
+	return start, end, nil
+}
+
+// BatchGetImage returns the manifests of the given images that have any,
+// reporting the others as not found.
+func (c *Client) BatchGetImage(
+	ctx context.Context,
+	input *ecr.BatchGetImageInput,
+	opts ...func(*ecr.Options),
+) (*ecr.BatchGetImageOutput, error) {
+	if err := c.call("BatchGetImage"); err != nil {
+		return nil, err
+	}
+	output := &ecr.BatchGetImageOutput{}
+	for _, id := range input.ImageIds {
+		id := id
+		manifest, ok := c.Manifests[aws.ToString(id.ImageDigest)]
+		if !ok {
+			output.Failures = append(output.Failures, types.ImageFailure{
+				FailureCode:   types.ImageFailureCodeImageNotFound,
+				FailureReason: aws.String("Requested image not found"),
+				ImageId:       &id,
+			})
+			continue
+		}
+		output.Images = append(output.Images, types.Image{
+			ImageId:        &id,
+			ImageManifest:  aws.String(manifest),
+			RegistryId:     input.RegistryId,
+			RepositoryName: input.RepositoryName,
+		})
+	}
+	return output, nil
+}
+
+// DescribeImages returns the details of the given images that have any.
+func (c *Client) DescribeImages(
+	ctx context.Context,
+	input *ecr.DescribeImagesInput,
+	opts ...func(*ecr.Options),
+) (*ecr.DescribeImagesOutput, error) {
+	if err := c.call("DescribeImages"); err != nil {
+		return nil, err
+	}
+	output := &ecr.DescribeImagesOutput{}
+	for _, id := range input.ImageIds {
+		if id.ImageDigest == nil && id.ImageTag != nil {
+			for _, detail := range c.Details {
+				for _, tag := range detail.ImageTags {
+					if tag == *id.ImageTag {
+						output.ImageDetails = append(output.ImageDetails, detail)
+					}
+				}
+			}
+			continue
+		}
+		if detail, ok := c.Details[aws.ToString(id.ImageDigest)]; ok {
+			output.ImageDetails = append(output.ImageDetails, detail)
+		}
+	}
+	return output, nil
+}
+
+// DescribeImageScanFindings returns the findings of the image, failing with
+// ScanNotFoundException if it has none.
+func (c *Client) DescribeImageScanFindings(
+	ctx context.Context,
+	input *ecr.DescribeImageScanFindingsInput,
+	opts ...func(*ecr.Options),
+) (*ecr.DescribeImageScanFindingsOutput, error) {
+	if err := c.call("DescribeImageScanFindings"); err != nil {
+		return nil, err
+	}
+	findings, ok := c.Findings[aws.ToString(input.ImageId.ImageDigest)]
+	if !ok {
+		return nil, &types.ScanNotFoundException{Message: aws.String("image has not been scanned")}
+	}
+	return &ecr.DescribeImageScanFindingsOutput{
+		ImageId:           input.ImageId,
+		ImageScanFindings: &findings,
+		ImageScanStatus:   &types.ImageScanStatus{Status: types.ScanStatusComplete},
+		RepositoryName:    input.RepositoryName,
+	}, nil
+}
+
+// DescribeRepositories returns a page of the repositories.
+func (c *Client) DescribeRepositories(
+	ctx context.Context,
+	input *ecr.DescribeRepositoriesInput,
+	opts ...func(*ecr.Options),
+) (*ecr.DescribeRepositoriesOutput, error) {
+	if err := c.call("DescribeRepositories"); err != nil {
+		return nil, err
+	}
+	start, end, next := c.page(input.NextToken, len(c.Repositories))
+	return &ecr.DescribeRepositoriesOutput{
+		NextToken:    next,
+		Repositories: c.Repositories[start:end],
+	}, nil
+}
+
+// GetRegistryScanningConfiguration returns the registry scanning configuration.
+func (c *Client) GetRegistryScanningConfiguration(
+	ctx context.Context,
+	input *ecr.GetRegistryScanningConfigurationInput,
+	opts ...func(*ecr.Options),
+) (*ecr.GetRegistryScanningConfigurationOutput, error) {
+	if err := c.call("GetRegistryScanningConfiguration"); err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return &ecr.GetRegistryScanningConfigurationOutput{
+		ScanningConfiguration: c.ScanningConfiguration,
+	}, nil
+}
+
+// ListImages returns a page of the images of the repository, failing with
+// RepositoryNotFoundException if there's no such repository.
+func (c *Client) ListImages(
+	ctx context.Context,
+	input *ecr.ListImagesInput,
+	opts ...func(*ecr.Options),
+) (*ecr.ListImagesOutput, error) {
+	if err := c.call("ListImages"); err != nil {
+		return nil, err
+	}
+	images, ok := c.Images[aws.ToString(input.RepositoryName)]
+	if !ok {
+		return nil, &types.RepositoryNotFoundException{Message: aws.String("repository does not exist")}
+	}
+	start, end, next := c.page(input.NextToken, len(images))
+	return &ecr.ListImagesOutput{
+		ImageIds:  images[start:end],
+		NextToken: next,
+	}, nil
+}
+
+// ListTagsForResource returns the tags of the repository.
+func (c *Client) ListTagsForResource(
+	ctx context.Context,
+	input *ecr.ListTagsForResourceInput,
+	opts ...func(*ecr.Options),
+) (*ecr.ListTagsForResourceOutput, error) {
+	if err := c.call("ListTagsForResource"); err != nil {
+		return nil, err
+	}
+	return &ecr.ListTagsForResourceOutput{
+		Tags: c.Tags[aws.ToString(input.ResourceArn)],
+	}, nil
+}
+
+// PutRegistryScanningConfiguration replaces the registry scanning
+// configuration.
+func (c *Client) PutRegistryScanningConfiguration(
+	ctx context.Context,
+	input *ecr.PutRegistryScanningConfigurationInput,
+	opts ...func(*ecr.Options),
+) (*ecr.PutRegistryScanningConfigurationOutput, error) {
+	if err := c.call("PutRegistryScanningConfiguration"); err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ScanningConfiguration = &types.RegistryScanningConfiguration{
+		Rules:    input.Rules,
+		ScanType: input.ScanType,
+	}
+	return &ecr.PutRegistryScanningConfigurationOutput{
+		RegistryScanningConfiguration: c.ScanningConfiguration,
+	}, nil
+}
+
+// StartImageScan records the scan request of the image.
+func (c *Client) StartImageScan(
+	ctx context.Context,
+	input *ecr.StartImageScanInput,
+	opts ...func(*ecr.Options),
+) (*ecr.StartImageScanOutput, error) {
+	if err := c.call("StartImageScan"); err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.scanned = append(c.scanned, *input.ImageId)
+	return &ecr.StartImageScanOutput{
+		ImageId:         input.ImageId,
+		ImageScanStatus: &types.ImageScanStatus{Status: types.ScanStatusInProgress},
+		RegistryId:      input.RegistryId,
+		RepositoryName:  input.RepositoryName,
+	}, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ExitRunFailed is the exit code used when a run finished with failures.
+	ExitRunFailed = 1
+
+	// ExitOverThreshold is the exit code used when a one-shot run found images
+	// with findings at or above the fail threshold.
+	ExitOverThreshold = 2
+
+	// ExitInvalidConfig is the exit code used when the configuration is invalid.
+	ExitInvalidConfig = 3
+
+	// ExitAWSError is the exit code used when the AWS configuration or
+	// credentials can't be loaded.
+	ExitAWSError = 4
+
+	// ExitInternalError is the exit code used when a part of the operator
+	// itself, such as the webserver or scheduler, fails.
+	ExitInternalError = 5
+
+	// ExitInvalidSchedule is the exit code used when the cron schedule is
+	// invalid, distinct from any other failure so it's apparent what needs
+	// fixing.
+	ExitInvalidSchedule = 78
+)
+
+var (
+	cleanupMutex sync.Mutex
+	cleanups     []func()
+)
+
+// OnExit registers a cleanup to run before the operator exits, whether it
+// shuts down normally or not. Cleanups run in the reverse order they were
+// registered in.
+func OnExit(cleanup func()) {
+	cleanupMutex.Lock()
+	defer cleanupMutex.Unlock()
+	cleanups = append(cleanups, cleanup)
+}
+
+// Cleanup runs every registered cleanup, at most once.
+func Cleanup() {
+	cleanupMutex.Lock()
+	pending := cleanups
+	cleanups = nil
+	cleanupMutex.Unlock()
+
+	for i := len(pending) - 1; i >= 0; i-- {
+		pending[i]()
+	}
+}
+
+// Exit runs every registered cleanup and exits with the given code.
+func Exit(code int) {
+	Cleanup()
+	os.Exit(code)
+}
+
+// Fatal logs the message as an error and exits with the given code, unlike
+// logrus' own Fatal which exits without cleaning up and always with 1.
+func Fatal(code int, entry *log.Entry, message string) {
+	entry.WithFields(log.Fields{
+		"code": code,
+	}).Error(message)
+	Exit(code)
+}
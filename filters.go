@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// RepositoryFileFallbackAll reconciles the repositories as if no file
+	// were configured when it's missing or empty.
+	RepositoryFileFallbackAll = "all"
+
+	// RepositoryFileFallbackNone reconciles no repositories at all when the
+	// file is missing or empty.
+	RepositoryFileFallbackNone = "none"
+
+	// RegexPatternPrefix marks a pattern as a regular expression rather than a
+	// glob pattern.
+	RegexPatternPrefix = "re:"
+)
+
+// patternExpressions caches the regular expressions of the patterns matched
+// against, so that each is only compiled once rather than for every name.
+var patternExpressions sync.Map
+
+// PatternExpression returns the regular expression of a pattern prefixed with
+// re:, which like a glob pattern has to match the whole name.
+func PatternExpression(pattern string) (*regexp.Regexp, error) {
+	if expression, ok := patternExpressions.Load(pattern); ok {
+		return expression.(*regexp.Regexp), nil
+	}
+	expression, err := regexp.Compile("^(?:" + strings.TrimPrefix(pattern, RegexPatternPrefix) + ")$")
+	if err != nil {
+		return nil, err
+	}
+	patternExpressions.Store(pattern, expression)
+	return expression, nil
+}
+
+// ValidatePatterns compiles the regular expressions among the patterns,
+// returning an error for the first that's invalid.
+func ValidatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if !strings.HasPrefix(pattern, RegexPatternPrefix) {
+			continue
+		}
+		if _, err := PatternExpression(pattern); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// MatchesAny reports whether the given name matches any of the provided glob
+// patterns, or regular expressions when prefixed with re:. Invalid patterns
+// are logged and treated as non-matching.
+func MatchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		var matched bool
+		var err error
+		if strings.HasPrefix(pattern, RegexPatternPrefix) {
+			var expression *regexp.Regexp
+			if expression, err = PatternExpression(pattern); err == nil {
+				matched = expression.MatchString(name)
+			}
+		} else {
+			matched, err = path.Match(pattern, name)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":     err,
+				"pattern": pattern,
+			}).Warn("invalid pattern, ignoring")
+			continue
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// EntriesFromFile reads the entries listed in the given file, either as a YAML
+// list or one per line. Blank lines and comments are ignored.
+func EntriesFromFile(file string) ([]string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// A list of one name per line isn't a YAML list, but a single string.
+	var entries []string
+	if err := yaml.Unmarshal(content, &entries); err != nil {
+		entries = strings.Split(string(content), "\n")
+	}
+
+	var listed []string
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		listed = append(listed, entry)
+	}
+	return listed, nil
+}
+
+// RepositoryIncludes returns the glob patterns of the repositories to
+// reconcile, those of the configured includes along with any listed in the
+// configured file. The file is read afresh on each call, so that changes to it,
+// such as to a mounted ConfigMap, take effect on the next run without a
+// restart. It reports false if no repositories should be reconciled at all, as
+// the file is missing or empty and its fallback is none.
+func RepositoryIncludes() ([]string, bool) {
+	includes := viper.GetStringSlice("repositories.include")
+	file := viper.GetString("repositories.from_file")
+	if file == "" {
+		return includes, true
+	}
+
+	// Setup our logging context for the function.
+	logger := log.WithFields(log.Fields{
+		"fallback": viper.GetString("repositories.from_file_fallback"),
+		"file":     file,
+	})
+
+	patterns, err := EntriesFromFile(file)
+	switch {
+	case err != nil:
+		logger.WithFields(log.Fields{
+			"err": err,
+		}).Warn("failed to read repositories file, falling back")
+	case len(patterns) == 0:
+		logger.Warn("repositories file lists no repositories, falling back")
+	default:
+		logger.WithFields(log.Fields{
+			"repositories": len(patterns),
+		}).Debug("read repositories file")
+		return append(append([]string(nil), includes...), patterns...), true
+	}
+	return includes, viper.GetString("repositories.from_file_fallback") == RepositoryFileFallbackAll
+}
+
+// ShouldReconcileRepository determines whether the repository with the given
+// name should be reconciled based on the configured exclude patterns and the
+// given include patterns. Excludes always win over includes, and an empty
+// include list selects every repository.
+func ShouldReconcileRepository(name string, includes []string) bool {
+	if MatchesAny(name, viper.GetStringSlice("repositories.exclude")) {
+		return false
+	}
+
+	if len(includes) == 0 {
+		return true
+	}
+	return MatchesAny(name, includes)
+}
+
+// ShouldReconcileImage determines whether the given image should be reconciled
+// based on the tag include and exclude patterns of its repository. Untagged
+// images can't match any pattern, so they're only reconciled if untagged images
+// aren't configured to be skipped.
+func ShouldReconcileImage(image types.ImageIdentifier, config RepositoryConfig) bool {
+	if image.ImageTag == nil {
+		return !config.SkipUntagged
+	}
+
+	if MatchesAny(*image.ImageTag, config.TagExclude) {
+		return false
+	}
+
+	includes := config.TagInclude
+	if len(includes) == 0 {
+		return true
+	}
+	return MatchesAny(*image.ImageTag, includes)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMatchesAny(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		want     bool
+	}{
+		{name: "team-a/api", patterns: []string{"team-a/api"}, want: true},
+		{name: "team-a/api", patterns: []string{"team-b/*", "team-a/*"}, want: true},
+		{name: "team-a/api", patterns: []string{"team-?/worker"}, want: false},
+		{name: "team-a/api", patterns: []string{`re:team-[a-c]/.+`}, want: true},
+		{name: "team-d/api", patterns: []string{`re:team-[a-c]/.+`}, want: false},
+		{name: "team-a/api", patterns: []string{`re:api`}, want: false},
+		{name: "team-a/api", patterns: []string{`re:.*api`}, want: true},
+		{name: "v1.2.3", patterns: []string{`re:v\d+\.\d+\.\d+`}, want: true},
+		{name: "v1.2.3-rc.1", patterns: []string{`re:v\d+\.\d+\.\d+`}, want: false},
+		{name: "team-a/api", patterns: []string{`re:a|team-a/api`}, want: true},
+		{name: "team-a/api", patterns: []string{"team-[a", `re:team-(a`, "team-a/*"}, want: true},
+		{name: "team-a/api", patterns: []string{"team-[a", `re:team-(a`}, want: false},
+		{name: "team-a/api", patterns: nil, want: false},
+	}
+	for _, test := range tests {
+		if got := MatchesAny(test.name, test.patterns); got != test.want {
+			t.Errorf("MatchesAny(%q, %q) = %t, want %t", test.name, test.patterns, got, test.want)
+		}
+	}
+}
+
+func TestValidatePatterns(t *testing.T) {
+	if err := ValidatePatterns([]string{"team-a/*", `re:team-[a-c]/.+`, "re:"}); err != nil {
+		t.Errorf("ValidatePatterns() error = %v, want nil", err)
+	}
+	if err := ValidatePatterns([]string{"team-a/*", `re:team-(a`}); err == nil {
+		t.Error("ValidatePatterns() error = nil, want an error for the invalid regular expression")
+	}
+}
+
+func TestShouldReconcileRepository(t *testing.T) {
+	tests := []struct {
+		name     string
+		exclude  []string
+		includes []string
+		want     map[string]bool
+	}{
+		{
+			name: "everything by default",
+			want: map[string]bool{"team-a/api": true, "sandbox": true},
+		},
+		{
+			name:     "included by glob",
+			includes: []string{"team-a/*"},
+			want:     map[string]bool{"team-a/api": true, "team-b/api": false},
+		},
+		{
+			name:     "included by regular expression",
+			includes: []string{`re:team-(a|b)/.*`},
+			want:     map[string]bool{"team-a/api": true, "team-b/api": true, "team-c/api": false},
+		},
+		{
+			name:    "excluded by regular expression",
+			exclude: []string{`re:.*-(sandbox|scratch)`},
+			want:    map[string]bool{"team-a/api": true, "team-a/api-sandbox": false, "team-b-scratch": false},
+		},
+		{
+			name:     "exclude wins over include",
+			exclude:  []string{"team-a/legacy-*"},
+			includes: []string{`re:team-a/.+`},
+			want:     map[string]bool{"team-a/api": true, "team-a/legacy-api": false, "team-b/api": false},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			configure(t, map[string]interface{}{"repositories.exclude": test.exclude})
+			for repository, want := range test.want {
+				if got := ShouldReconcileRepository(repository, test.includes); got != want {
+					t.Errorf("ShouldReconcileRepository(%q) = %t, want %t", repository, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+
+	"golang.org/x/time/rate"
+)
+
+// severityRanks orders the finding severities from least to most severe.
+var severityRanks = map[types.FindingSeverity]int{
+	types.FindingSeverityUndefined:     0,
+	types.FindingSeverityInformational: 1,
+	types.FindingSeverityLow:           2,
+	types.FindingSeverityMedium:        3,
+	types.FindingSeverityHigh:          4,
+	types.FindingSeverityCritical:      5,
+}
+
+// ParseSeverity parses the given finding severity case-insensitively.
+func ParseSeverity(value string) (types.FindingSeverity, error) {
+	severity := types.FindingSeverity(strings.ToUpper(value))
+	if _, ok := severityRanks[severity]; !ok {
+		return "", fmt.Errorf("unknown finding severity %q", value)
+	}
+	return severity, nil
+}
+
+// ParseSeverities parses the given list of finding severities, as per
+// ParseSeverity.
+func ParseSeverities(values []string) ([]types.FindingSeverity, error) {
+	var severities []types.FindingSeverity
+	for _, value := range values {
+		severity, err := ParseSeverity(value)
+		if err != nil {
+			return nil, err
+		}
+		severities = append(severities, severity)
+	}
+	return severities, nil
+}
+
+// IncludedSeverities returns the finding severities we count, which is all of
+// them unless configured otherwise.
+func IncludedSeverities() []types.FindingSeverity {
+	severities, err := ParseSeverities(viper.GetStringSlice("scan.severities"))
+	if err != nil || len(severities) == 0 {
+		return types.FindingSeverity("").Values()
+	}
+	return severities
+}
+
+// FilterFindings returns only the findings of the given severities.
+func FilterFindings(findings *ImageFindings, severities []types.FindingSeverity) *ImageFindings {
+	included := map[string]bool{}
+	for _, severity := range severities {
+		included[string(severity)] = true
+	}
+
+	filtered := &ImageFindings{
+		Counts:      map[string]int32{},
+		CompletedAt: findings.CompletedAt,
+	}
+	for severity, count := range findings.Counts {
+		if included[severity] {
+			filtered.Counts[severity] = count
+		}
+	}
+	for _, finding := range findings.Details {
+		if included[string(finding.Severity)] {
+			filtered.Details = append(filtered.Details, finding)
+		}
+	}
+	return filtered
+}
+
+// CountAtOrAbove counts the findings with a severity at or above the given
+// threshold.
+func CountAtOrAbove(counts map[string]int32, threshold types.FindingSeverity) int32 {
+	var total int32
+	for severity, count := range counts {
+		if rank, ok := severityRanks[types.FindingSeverity(severity)]; ok && rank >= severityRanks[threshold] {
+			total += count
+		}
+	}
+	return total
+}
+
+// ImageFindings are the findings from the most recently completed scan of an
+// image.
+type ImageFindings struct {
+	// Counts are the number of findings by severity.
+	Counts map[string]int32
+
+	// Details are the individual findings, only populated if requested.
+	Details []types.ImageScanFinding
+
+	// CompletedAt is when the scan completed, if known.
+	CompletedAt *time.Time
+}
+
+// NewFindingsLimiter creates the limiter pacing our requests for image scan
+// findings, or nil if they aren't paced. They're paced separately from scan
+// requests, as AWS ECR throttles each separately.
+func NewFindingsLimiter() *rate.Limiter {
+	limit := viper.GetFloat64("findings.rate_limit")
+	if limit <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(limit), 1)
+}
+
+// NewFindingsPool creates the pool bounding how many images have their scan
+// findings described at once across all repositories.
+func NewFindingsPool() *WorkerPool {
+	concurrency := viper.GetInt("findings.concurrency")
+	if concurrency < 1 {
+		log.WithFields(log.Fields{
+			"concurrency": concurrency,
+		}).Warn("invalid findings concurrency, defaulting to 5")
+		concurrency = 5
+	}
+	return NewWorkerPool(concurrency)
+}
+
+// FetchImageFindings retrieves the findings from the most recently completed
+// scan of the given image. The individual findings are only retrieved if
+// details are requested, as that may take several requests. If the image has
+// no completed scan nil is returned.
+func FetchImageFindings(
+	ctx context.Context,
+	registry Registry,
+	repository types.Repository,
+	image types.ImageIdentifier,
+	details bool,
+) (*ImageFindings, error) {
+	paginator := ecr.NewDescribeImageScanFindingsPaginator(registry.Client, &ecr.DescribeImageScanFindingsInput{
+		ImageId:        &image,
+		RegistryId:     registry.ID,
+		RepositoryName: repository.RepositoryName,
+	})
+
+	var findings *ImageFindings
+	for paginator.HasMorePages() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		response, err := paginator.NextPage(ctx)
+		if err != nil {
+			// An image that has never been scanned simply has no findings yet.
+			var snfe *types.ScanNotFoundException
+			if errors.As(err, &snfe) {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		// Findings are only meaningful once the scan has completed.
+		if response.ImageScanStatus == nil ||
+			response.ImageScanStatus.Status != types.ScanStatusComplete ||
+			response.ImageScanFindings == nil {
+			return nil, nil
+		}
+
+		if findings == nil {
+			findings = &ImageFindings{
+				Counts:      response.ImageScanFindings.FindingSeverityCounts,
+				CompletedAt: response.ImageScanFindings.ImageScanCompletedAt,
+			}
+		}
+		if !details {
+			break
+		}
+		findings.Details = append(findings.Details, response.ImageScanFindings.Findings...)
+	}
+	return findings, nil
+}
+
+// CollectFindings reads the findings of the most recent scans of the given
+// images and exports the totals by severity for the repository. If a threshold
+// is given, the number of images with findings at or above it is returned.
+func CollectFindings(
+	ctx context.Context,
+	registry Registry,
+	repository types.Repository,
+	images []types.ImageIdentifier,
+	threshold types.FindingSeverity,
+) int64 {
+	// Setup our logging context for the function.
+	logger := registry.Logger.WithFields(log.Fields{
+		"region":     registry.Region,
+		"repository": *repository.RepositoryName,
+	})
+	logger.Debug("collecting image scan findings")
+
+	// Start every severity we count at zero so that resolved findings are
+	// reflected.
+	severities := IncludedSeverities()
+	totals := map[string]int32{}
+	for _, severity := range severities {
+		totals[string(severity)] = 0
+	}
+
+	var over int64
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	details := SARIFEnabled() || JSONLEnabled()
+	collect := func(image types.ImageIdentifier) {
+		// Wait for our turn if we're pacing our requests, which may take until
+		// the run is cancelled.
+		if registry.FindingsLimiter != nil {
+			if err := registry.FindingsLimiter.Wait(ctx); err != nil {
+				logger.WithFields(log.Fields{
+					"err": err,
+				}).Debug("run cancelled while waiting to describe image scan findings")
+				return
+			}
+		}
+
+		findings, err := FetchImageFindings(ctx, registry, repository, image, details)
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"err": err,
+				"image": map[string]string{
+					"digest": *image.ImageDigest,
+					"tag":    ImageTag(image),
+				},
+			}).Error("failed to describe image scan findings")
+			return
+		}
+		if findings == nil {
+			return
+		}
+		findings = FilterFindings(findings, severities)
+		counts := findings.Counts
+
+		mutex.Lock()
+		for severity, count := range counts {
+			totals[severity] += count
+		}
+		mutex.Unlock()
+		if threshold != "" && CountAtOrAbove(counts, threshold) > 0 {
+			logger.WithFields(log.Fields{
+				"findings": counts,
+				"image": map[string]string{
+					"digest": *image.ImageDigest,
+					"tag":    ImageTag(image),
+				},
+				"threshold": threshold,
+			}).Warn("image has findings at or above the fail threshold")
+			mutex.Lock()
+			over++
+			mutex.Unlock()
+		}
+
+		notification := NewScanNotification(
+			EventFindingsCollected,
+			registry,
+			repository,
+			image,
+			counts,
+		)
+		notification.Details = findings.Details
+		notification.ScanCompletedAt = findings.CompletedAt
+		Notify(ctx, registry.Notifiers, notification)
+	}
+
+	// Describe the findings of several images at once if we've a pool to do so,
+	// and wait for all of them to finish.
+	for _, image := range images {
+		image := image
+		if registry.FindingsPool == nil {
+			collect(image)
+			continue
+		}
+		wg.Add(1)
+		registry.FindingsPool.Submit(func() {
+			defer wg.Done()
+			collect(image)
+		})
+	}
+	wg.Wait()
+
+	for severity, count := range totals {
+		registry.Metrics.ImageFindings.WithLabelValues(
+			registry.Region,
+			RepositoryLabel(repository),
+			severity,
+		).Set(float64(count))
+	}
+	if threshold != "" {
+		registry.Metrics.ImagesOverThreshold.WithLabelValues(
+			registry.Region,
+			RepositoryLabel(repository),
+		).Set(float64(over))
+	}
+	return over
+}
+
+// FindingsCollector collects the findings of the images whose scans were
+// recently requested on a schedule of its own, as the findings of a scan only
+// appear some minutes after it's requested, rather than leaving them to the
+// next run. It shares the pool and limiter describing the findings of images
+// with our runs, so that together they stay within their bounds.
+type FindingsCollector struct {
+	// Pool is the worker pool the findings of images are described through.
+	Pool *WorkerPool
+
+	// Limiter paces our requests for image scan findings, if at all.
+	Limiter *rate.Limiter
+
+	// Lookback is how long after their scans were last requested the findings
+	// of a repository's images keep being collected.
+	Lookback time.Duration
+
+	mutex   sync.Mutex
+	running sync.Mutex
+	recent  map[string]recentScans
+}
+
+// recentScans are the images of a repository whose scans were recently
+// requested.
+type recentScans struct {
+	registry    Registry
+	repository  types.Repository
+	images      []types.ImageIdentifier
+	requestedAt time.Time
+}
+
+// NewFindingsCollector creates the collector of the findings of recent scans,
+// with a pool and limiter of its own for our runs to share.
+func NewFindingsCollector(lookback time.Duration) *FindingsCollector {
+	return &FindingsCollector{
+		Pool:     NewFindingsPool(),
+		Limiter:  NewFindingsLimiter(),
+		Lookback: lookback,
+		recent:   map[string]recentScans{},
+	}
+}
+
+// Remember records that scans of the repository were requested, so that the
+// findings of the given images are collected until the lookback passes. A nil
+// collector remembers nothing.
+func (c *FindingsCollector) Remember(
+	registry Registry,
+	repository types.Repository,
+	images []types.ImageIdentifier,
+) {
+	if c == nil {
+		return
+	}
+
+	// Only the metrics and any findings output are updated with the findings,
+	// which would otherwise be notified again on every collection.
+	registry.Notifiers = nil
+	if findingsLines != nil {
+		registry.Notifiers = []Notifier{findingsLines}
+	}
+	registry.FindingsLimiter = c.Limiter
+	registry.FindingsPool = c.Pool
+
+	key := fmt.Sprintf("%s/%s/%s", RegistryName(registry), aws.ToString(registry.ID), *repository.RepositoryName)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.recent[key] = recentScans{
+		registry:    registry,
+		repository:  repository,
+		images:      images,
+		requestedAt: time.Now(),
+	}
+}
+
+// Collect collects the findings of every repository whose scans were requested
+// within the lookback, forgetting the others, unless a collection is already
+// in progress. It reports whether the collection happened.
+func (c *FindingsCollector) Collect(ctx context.Context) bool {
+	if !c.running.TryLock() {
+		return false
+	}
+	defer c.running.Unlock()
+
+	var repositories []recentScans
+	c.mutex.Lock()
+	for key, recent := range c.recent {
+		if time.Since(recent.requestedAt) > c.Lookback {
+			delete(c.recent, key)
+			continue
+		}
+		repositories = append(repositories, recent)
+	}
+	c.mutex.Unlock()
+
+	// Each collection is a run of its own as far as our logs are concerned,
+	// rather than part of the run that requested the scans.
+	logger := log.WithFields(log.Fields{
+		"run": NewRunID(),
+	})
+	threshold, _ := ParseSeverity(viper.GetString("scan.fail_threshold"))
+	for _, recent := range repositories {
+		if ctx.Err() != nil {
+			break
+		}
+		recent.registry.Logger = logger
+		CollectFindings(ctx, recent.registry, recent.repository, recent.images, threshold)
+	}
+	logger.WithFields(log.Fields{
+		"repositories": len(repositories),
+	}).Debug("collected findings of recent scans")
+	return true
+}
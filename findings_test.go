@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+func TestCollectFindingsRepositoryLabel(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		label   string
+	}{
+		{name: "enabled", enabled: true, label: "repository-0"},
+		{name: "disabled", enabled: false, label: ""},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			configure(t, map[string]interface{}{"metrics.repository_label": test.enabled})
+			client := fakeRegistry(1, 2)
+			client.Findings = map[string]types.ImageScanFindings{
+				"sha256:00": {FindingSeverityCounts: map[string]int32{"CRITICAL": 1, "HIGH": 2}},
+				"sha256:01": {FindingSeverityCounts: map[string]int32{"CRITICAL": 2}},
+			}
+			registry := testRegistry(client)
+			repository := client.Repositories[0]
+
+			over := CollectFindings(context.Background(), registry, repository, client.Images["repository-0"], types.FindingSeverityCritical)
+			if over != 2 {
+				t.Errorf("CollectFindings() = %d, want 2 images over the threshold", over)
+			}
+			if got := testutil.ToFloat64(registry.Metrics.ImageFindings.WithLabelValues("us-east-1", test.label, "CRITICAL")); got != 3 {
+				t.Errorf("aws_ecr_image_findings{repository=%q} = %v, want 3", test.label, got)
+			}
+			if got := testutil.ToFloat64(registry.Metrics.ImagesOverThreshold.WithLabelValues("us-east-1", test.label)); got != 2 {
+				t.Errorf("aws_ecr_images_over_threshold{repository=%q} = %v, want 2", test.label, got)
+			}
+			if got := testutil.CollectAndCount(registry.Metrics.ImagesOverThreshold); got != 1 {
+				t.Errorf("aws_ecr_images_over_threshold has %d series, want 1", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/procyon-projects/chrono"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// FailurePolicyIgnore treats runs with failures like any other run.
+	FailurePolicyIgnore = "ignore"
+
+	// FailurePolicyWarn makes the operator not ready after a run with failures.
+	FailurePolicyWarn = "warn"
+
+	// FailurePolicyCrash exits the operator after a run with failures.
+	FailurePolicyCrash = "crash"
+
+	// RegionFailurePolicyFail counts a region whose repositories can't be
+	// described as a failure of the run.
+	RegionFailurePolicyFail = "fail"
+
+	// RegionFailurePolicyIgnore only logs and counts a region whose
+	// repositories can't be described, such as where AWS ECR isn't in use.
+	RegionFailurePolicyIgnore = "ignore"
+)
+
+// Health tracks the liveness and readiness of the operator for the benefit of
+// orchestration probes.
+type Health struct {
+	// Leader tracks whether we're the replica running scans, replicas standing
+	// by are ready regardless of the runs they don't perform.
+	Leader *Leader
+
+	// Scheduler is the scheduler running our scans, if any, the operator is
+	// only considered alive while it's running.
+	Scheduler chrono.TaskScheduler
+
+	// Threshold is the number of consecutive failed runs after which the
+	// operator is no longer considered ready.
+	Threshold int64
+
+	// Policy is how runs that partially failed affect our readiness.
+	Policy string
+
+	ready    atomic.Bool
+	failures atomic.Int64
+}
+
+// MarkReady makes the operator ready as soon as it's shown it can reach AWS,
+// such as by passing its preflight check or describing the repositories of a
+// registry, rather than only once a whole run succeeds which may be hours
+// away. It has no effect once runs have failed, as only a run succeeding makes
+// us ready again then. It's safe to call on a nil Health.
+func (h *Health) MarkReady(reason string) {
+	if h == nil || h.failures.Load() > 0 {
+		return
+	}
+	if !h.ready.Swap(true) {
+		log.WithFields(log.Fields{
+			"reason": reason,
+		}).Info("operator is ready")
+	}
+}
+
+// Record updates the readiness of the operator with the result of a run.
+func (h *Health) Record(result RunResult) {
+	// Under the warn policy any failure at all makes us not ready right away,
+	// until a run completes without any.
+	if h.Policy == FailurePolicyWarn && result.Failures > 0 {
+		h.failures.Add(1)
+		if h.ready.Swap(false) {
+			log.WithFields(log.Fields{
+				"failures": result.Failures,
+			}).Warn("run finished with failures, operator is no longer ready")
+		}
+		return
+	}
+
+	if result.Succeeded() {
+		h.failures.Store(0)
+		if !h.ready.Swap(true) {
+			log.Info("operator is ready")
+		}
+		return
+	}
+
+	failures := h.failures.Add(1)
+	if failures >= h.Threshold && h.ready.Swap(false) {
+		log.WithFields(log.Fields{
+			"failures": failures,
+		}).Warn("consecutive runs failed, operator is no longer ready")
+	}
+}
+
+// ServeHealth responds successfully as long as the scheduler is running.
+func (h *Health) ServeHealth(w http.ResponseWriter, r *http.Request) {
+	if h.Scheduler != nil && h.Scheduler.IsShutdown() {
+		http.Error(w, "scheduler is not running", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// ServeReady responds successfully once the operator has reached AWS, until
+// too many consecutive runs fail. Replicas that aren't the leader are always ready.
+func (h *Health) ServeReady(w http.ResponseWriter, r *http.Request) {
+	if h.Leader != nil && !h.Leader.IsLeader() {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !h.ready.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
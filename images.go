@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// describeImagesBatchSize is the maximum number of image IDs AWS ECR accepts in
+// a single DescribeImages request.
+const describeImagesBatchSize = 100
+
+const (
+	// ScanOrderNone requests the scans of a repository's images in the order
+	// AWS ECR lists them in.
+	ScanOrderNone = "none"
+
+	// ScanOrderNewest requests the scans of the most recently pushed images
+	// first.
+	ScanOrderNewest = "newest"
+
+	// ScanOrderOldest requests the scans of the least recently pushed images
+	// first.
+	ScanOrderOldest = "oldest"
+
+	// ScanOrderRandom requests the scans of a repository's images in a random
+	// order.
+	ScanOrderRandom = "random"
+
+	// ScanOrderName requests the scans of a repository's images in the order of
+	// their tags, then digests.
+	ScanOrderName = "name"
+)
+
+// orderRandom shuffles images for the random scan order, seeded from the clock
+// as the global source isn't seeded on every Go version we build with.
+var orderRandom = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// FetchImageDetails describes the given images, which provides details such as
+// push and scan times that ListImages doesn't return, keyed by image digest.
+func FetchImageDetails(
+	ctx context.Context,
+	registry Registry,
+	repository types.Repository,
+	images []types.ImageIdentifier,
+) (map[string]types.ImageDetail, error) {
+	details := map[string]types.ImageDetail{}
+	for start := 0; start < len(images); start += describeImagesBatchSize {
+		end := start + describeImagesBatchSize
+		if end > len(images) {
+			end = len(images)
+		}
+
+		// Describe the images by digest alone, as several tags may share one.
+		var ids []types.ImageIdentifier
+		for _, image := range images[start:end] {
+			ids = append(ids, types.ImageIdentifier{ImageDigest: image.ImageDigest})
+		}
+
+		response, err := registry.Client.DescribeImages(ctx, &ecr.DescribeImagesInput{
+			ImageIds:       ids,
+			RegistryId:     registry.ID,
+			RepositoryName: repository.RepositoryName,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, detail := range response.ImageDetails {
+			if detail.ImageDigest != nil {
+				details[*detail.ImageDigest] = detail
+			}
+		}
+	}
+	return details, nil
+}
+
+// PushedAt returns the time the given image was pushed according to its
+// details, or the zero time if it isn't known.
+func PushedAt(detail types.ImageDetail) time.Time {
+	if detail.ImagePushedAt == nil {
+		return time.Time{}
+	}
+	return *detail.ImagePushedAt
+}
+
+// SkipOldImages returns the images pushed within the given maximum age of now.
+// Images whose push time isn't known are kept.
+func SkipOldImages(
+	registry Registry,
+	repository types.Repository,
+	images []types.ImageIdentifier,
+	details map[string]types.ImageDetail,
+	age time.Duration,
+	now time.Time,
+) []types.ImageIdentifier {
+	// Setup our logging context for the function.
+	logger := registry.Logger.WithFields(log.Fields{
+		"region":     registry.Region,
+		"repository": *repository.RepositoryName,
+	})
+
+	var remaining []types.ImageIdentifier
+	cutoff := now.Add(-age)
+	for _, image := range images {
+		pushed := PushedAt(details[*image.ImageDigest])
+		if !pushed.IsZero() && pushed.Before(cutoff) {
+			logger.WithFields(log.Fields{
+				"image": map[string]string{
+					"digest": *image.ImageDigest,
+					"tag":    ImageTag(image),
+				},
+				"pushed": pushed,
+			}).Debug("image pushed before the maximum age, skipping")
+			registry.Metrics.ImagesSkippedAge.WithLabelValues(registry.Region).Inc()
+			continue
+		}
+		remaining = append(remaining, image)
+	}
+	return remaining
+}
+
+// IsTagMutable reports whether the repository allows its tags to be
+// overwritten. Repositories given by name rather than described aren't known
+// to be mutable.
+func IsTagMutable(repository types.Repository) bool {
+	return repository.ImageTagMutability == types.ImageTagMutabilityMutable
+}
+
+// SelectTaggedImages returns only the images carrying the given tag.
+func SelectTaggedImages(images []types.ImageIdentifier, tag string) []types.ImageIdentifier {
+	var selected []types.ImageIdentifier
+	for _, image := range images {
+		if image.ImageTag != nil && *image.ImageTag == tag {
+			selected = append(selected, image)
+		}
+	}
+	return selected
+}
+
+// DeduplicateImages returns a single identifier per image digest, as ListImages
+// returns an identifier per tag and scans apply to the digest regardless.
+func DeduplicateImages(
+	registry Registry,
+	repository types.Repository,
+	images []types.ImageIdentifier,
+) []types.ImageIdentifier {
+	// Gather up every tag of each digest, keeping the first identifier we see.
+	var unique []types.ImageIdentifier
+	tags := map[string][]string{}
+	for _, image := range images {
+		digest := *image.ImageDigest
+		if _, ok := tags[digest]; !ok {
+			unique = append(unique, image)
+		}
+		tags[digest] = append(tags[digest], ImageTag(image))
+	}
+
+	if duplicates := len(images) - len(unique); duplicates > 0 {
+		for digest, all := range tags {
+			if len(all) < 2 {
+				continue
+			}
+			registry.Logger.WithFields(log.Fields{
+				"digest":     digest,
+				"region":     registry.Region,
+				"repository": *repository.RepositoryName,
+				"tags":       all,
+			}).Debug("image has multiple tags, scanning its digest once")
+		}
+		registry.Metrics.ImagesDeduplicated.WithLabelValues(registry.Region).Add(float64(duplicates))
+	}
+	return unique
+}
+
+// SelectNewestImages returns at most the given number of images, preferring the
+// most recently pushed ones, along with the count of images left out.
+func SelectNewestImages(
+	images []types.ImageIdentifier,
+	details map[string]types.ImageDetail,
+	limit int,
+) ([]types.ImageIdentifier, int) {
+	if limit <= 0 || len(images) <= limit {
+		return images, 0
+	}
+
+	sorted := make([]types.ImageIdentifier, len(images))
+	copy(sorted, images)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return PushedAt(details[*sorted[i].ImageDigest]).After(
+			PushedAt(details[*sorted[j].ImageDigest]),
+		)
+	})
+	return sorted[:limit], len(images) - limit
+}
+
+// OrderImages returns the images in the given scan order. Images whose push
+// time isn't known are ordered as if pushed before any other.
+func OrderImages(
+	images []types.ImageIdentifier,
+	details map[string]types.ImageDetail,
+	order string,
+) []types.ImageIdentifier {
+	ordered := make([]types.ImageIdentifier, len(images))
+	copy(ordered, images)
+	switch order {
+	case ScanOrderNewest:
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return PushedAt(details[*ordered[i].ImageDigest]).After(
+				PushedAt(details[*ordered[j].ImageDigest]),
+			)
+		})
+	case ScanOrderOldest:
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return PushedAt(details[*ordered[i].ImageDigest]).Before(
+				PushedAt(details[*ordered[j].ImageDigest]),
+			)
+		})
+	case ScanOrderRandom:
+		orderRandom.Lock()
+		orderRandom.Shuffle(len(ordered), func(i, j int) {
+			ordered[i], ordered[j] = ordered[j], ordered[i]
+		})
+		orderRandom.Unlock()
+	case ScanOrderName:
+		sort.SliceStable(ordered, func(i, j int) bool {
+			if ImageTag(ordered[i]) != ImageTag(ordered[j]) {
+				return ImageTag(ordered[i]) < ImageTag(ordered[j])
+			}
+			return *ordered[i].ImageDigest < *ordered[j].ImageDigest
+		})
+	default:
+		return images
+	}
+	return ordered
+}
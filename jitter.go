@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/procyon-projects/chrono"
+)
+
+// Jitter produces random delays of up to a maximum, used to spread the start of
+// scheduled runs. It's safe for concurrent use and deterministic for a given
+// seed.
+type Jitter struct {
+	Max time.Duration
+
+	mutex  sync.Mutex
+	random *rand.Rand
+}
+
+// NewJitter creates a new jitter of up to the given maximum from the seed.
+func NewJitter(max time.Duration, seed int64) *Jitter {
+	return &Jitter{
+		Max:    max,
+		random: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Delay returns the next random delay, between zero and the maximum.
+func (j *Jitter) Delay() time.Duration {
+	if j.Max <= 0 {
+		return 0
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return time.Duration(j.random.Int63n(int64(j.Max)))
+}
+
+// ScheduleInterval returns the shortest interval between the next few runs of
+// the cron expression after the given time, as that's what decides how soon
+// an image may be scanned again.
+func ScheduleInterval(expression *chrono.CronExpression, from time.Time) time.Duration {
+	var interval time.Duration
+	previous := expression.NextTime(from)
+	for i := 0; i < 32; i++ {
+		next := expression.NextTime(previous)
+		if next.IsZero() {
+			break
+		}
+		if gap := next.Sub(previous); interval == 0 || gap < interval {
+			interval = gap
+		}
+		previous = next
+	}
+	return interval
+}
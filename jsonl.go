@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// findingsLines is where every registry writes the findings it collects as
+// JSON Lines, if anywhere.
+var findingsLines *FindingsLines
+
+// JSONLEnabled reports whether findings should be written as JSON Lines.
+func JSONLEnabled() bool {
+	return viper.GetBool("output.jsonl.enabled")
+}
+
+// FindingRecord is a single finding of an image, written as a line of JSON.
+type FindingRecord struct {
+	Region         string `json:"region"`
+	Repository     string `json:"repository"`
+	ImageDigest    string `json:"imageDigest"`
+	ImageTag       string `json:"imageTag"`
+	CVE            string `json:"cve"`
+	Severity       string `json:"severity"`
+	Package        string `json:"package"`
+	PackageVersion string `json:"packageVersion"`
+	URI            string `json:"uri,omitempty"`
+}
+
+// NewFindingRecords returns a record of each of the findings of the
+// notification.
+func NewFindingRecords(notification ScanNotification) []FindingRecord {
+	records := make([]FindingRecord, 0, len(notification.Details))
+	for _, finding := range notification.Details {
+		records = append(records, FindingRecord{
+			Region:         notification.Region,
+			Repository:     notification.Repository,
+			ImageDigest:    notification.ImageDigest,
+			ImageTag:       notification.ImageTag,
+			CVE:            aws.ToString(finding.Name),
+			Severity:       string(finding.Severity),
+			Package:        FindingAttribute(finding, "package_name"),
+			PackageVersion: FindingAttribute(finding, "package_version"),
+			URI:            aws.ToString(finding.Uri),
+		})
+	}
+	return records
+}
+
+// FindingsLines writes each finding collected as a line of JSON, for piping
+// into tools such as jq or ingesting into a pipeline.
+type FindingsLines struct {
+	Metrics *Metrics
+
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+// SetupFindingsLines opens the configured findings output, if enabled, for
+// every registry to write the findings it collects to. Findings go to standard
+// output unless a file is configured, which is appended to.
+func SetupFindingsLines(metrics *Metrics) error {
+	if !JSONLEnabled() {
+		return nil
+	}
+
+	var writer io.Writer = os.Stdout
+	if path := viper.GetString("output.jsonl.path"); path != "" {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		OnExit(func() {
+			if err := file.Close(); err != nil {
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Warn("failed to close findings output")
+			}
+		})
+		writer = file
+	}
+
+	findingsLines = &FindingsLines{Metrics: metrics, writer: writer}
+	return nil
+}
+
+// Notify writes the findings among the notifications, those of each image at
+// once so that they aren't interleaved with those of another. Failures are
+// logged and counted but never returned, as the output must not abort
+// reconciliation.
+func (f *FindingsLines) Notify(ctx context.Context, notification ScanNotification) {
+	if notification.Event != EventFindingsCollected || len(notification.Details) == 0 {
+		return
+	}
+
+	var lines bytes.Buffer
+	encoder := json.NewEncoder(&lines)
+	var err error
+	for _, record := range NewFindingRecords(notification) {
+		if err = encoder.Encode(record); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		f.mutex.Lock()
+		_, err = f.writer.Write(lines.Bytes())
+		f.mutex.Unlock()
+	}
+	if err != nil {
+		f.Metrics.FindingsOutputErrors.Inc()
+		log.WithFields(log.Fields{
+			"err":        err,
+			"repository": notification.Repository,
+		}).Error("failed to write findings")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+func TestFindingsLinesNotify(t *testing.T) {
+	var output bytes.Buffer
+	lines := &FindingsLines{Metrics: NewMetrics(prometheus.NewRegistry()), writer: &output}
+	lines.Notify(context.Background(), ScanNotification{
+		Event:       EventFindingsCollected,
+		Region:      "us-east-1",
+		Repository:  "web",
+		ImageDigest: "sha256:bbb",
+		ImageTag:    "v2",
+		Details: []types.ImageScanFinding{
+			sampleFinding("CVE-2022-0001", types.FindingSeverityCritical, "zlib", "1:1.2.11"),
+			sampleFinding("CVE-2022-0002", types.FindingSeverityMedium, "openssl", "1.1.1n-0"),
+		},
+	})
+	// Notifications of anything but collected findings are left out.
+	lines.Notify(context.Background(), ScanNotification{
+		Event:      EventScanRequested,
+		Repository: "web",
+		Details:    []types.ImageScanFinding{sampleFinding("CVE-2022-0003", types.FindingSeverityLow, "bash", "5.1-2")},
+	})
+
+	if !strings.HasSuffix(output.String(), "\n") {
+		t.Fatalf("output %q doesn't end with a newline", output.String())
+	}
+	records := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	if len(records) != 2 {
+		t.Fatalf("wrote %d lines, want one per finding:\n%s", len(records), output.String())
+	}
+
+	fields := []string{"cve", "imageDigest", "imageTag", "package", "packageVersion", "region", "repository", "severity", "uri"}
+	for i, want := range []string{"CVE-2022-0001", "CVE-2022-0002"} {
+		var record map[string]string
+		if err := json.Unmarshal([]byte(records[i]), &record); err != nil {
+			t.Fatalf("line %d isn't a JSON object: %v", i+1, err)
+		}
+		keys := make([]string, 0, len(record))
+		for key := range record {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, fields) {
+			t.Errorf("line %d has fields %v, want %v", i+1, keys, fields)
+		}
+		if record["cve"] != want || record["repository"] != "web" || record["imageDigest"] != "sha256:bbb" {
+			t.Errorf("line %d = %v, want the finding %s of web@sha256:bbb", i+1, record, want)
+		}
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestFindingsLinesNotifyWriteFails(t *testing.T) {
+	lines := &FindingsLines{Metrics: NewMetrics(prometheus.NewRegistry()), writer: failingWriter{}}
+	lines.Notify(context.Background(), ScanNotification{
+		Event:   EventFindingsCollected,
+		Details: []types.ImageScanFinding{sampleFinding("CVE-2022-0001", types.FindingSeverityCritical, "zlib", "1:1.2.11")},
+	})
+	if got := testutil.ToFloat64(lines.Metrics.FindingsOutputErrors); got != 1 {
+		t.Errorf("aws_ecr_findings_output_errors = %v, want 1", got)
+	}
+}
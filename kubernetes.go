@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// namespaceFile is where Kubernetes mounts the namespace of the pod we're
+// running in.
+const namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// ImageScanResource is the custom resource recording the scans of an image, as
+// defined by the CRD in the crds directory.
+var ImageScanResource = schema.GroupVersionResource{
+	Group:    "ecrscan.celestialorb.io",
+	Version:  "v1alpha1",
+	Resource: "imagescans",
+}
+
+// invalidNameCharacters matches everything not allowed in the name of a
+// Kubernetes object.
+var invalidNameCharacters = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// Namespace returns the namespace configured at the given key, falling back to
+// the namespace of the pod we're running in.
+func Namespace(key string) string {
+	if namespace := viper.GetString(key); namespace != "" {
+		return namespace
+	}
+	if namespace, err := os.ReadFile(namespaceFile); err == nil {
+		return strings.TrimSpace(string(namespace))
+	}
+	return "default"
+}
+
+// ImageScanName returns the name of the ImageScan resource of an image, which
+// is unique to the image while remaining a valid object name.
+func ImageScanName(notification ScanNotification) string {
+	name := invalidNameCharacters.ReplaceAllString(
+		strings.ToLower(notification.Region+"-"+notification.Repository),
+		"-",
+	)
+	name = strings.Trim(name, "-")
+	if len(name) > 200 {
+		name = name[:200]
+	}
+
+	digest := strings.TrimPrefix(notification.ImageDigest, "sha256:")
+	if len(digest) > 16 {
+		digest = digest[:16]
+	}
+	return name + "-" + digest
+}
+
+// KubernetesNotifier records the scans of each image as ImageScan resources,
+// so that the state of the scans can be seen from within the cluster.
+type KubernetesNotifier struct {
+	Client    dynamic.NamespaceableResourceInterface
+	Metrics   *Metrics
+	Namespace string
+}
+
+// NewKubernetesNotifier creates a notifier writing ImageScan resources to the
+// configured namespace of the cluster we're running in.
+func NewKubernetesNotifier(metrics *Metrics) (*KubernetesNotifier, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &KubernetesNotifier{
+		Client:    client.Resource(ImageScanResource),
+		Metrics:   metrics,
+		Namespace: Namespace("kubernetes.namespace"),
+	}, nil
+}
+
+// Notify creates or updates the ImageScan resource of the image with the
+// notification. Failures are logged and counted but otherwise ignored.
+func (n *KubernetesNotifier) Notify(ctx context.Context, notification ScanNotification) {
+	logger := log.WithFields(log.Fields{
+		"event":      notification.Event,
+		"name":       ImageScanName(notification),
+		"namespace":  n.Namespace,
+		"region":     notification.Region,
+		"repository": notification.Repository,
+	})
+
+	// Each event only fills in what it knows about, merging with what the
+	// resource already holds.
+	status := map[string]interface{}{}
+	switch notification.Event {
+	case EventScanRequested:
+		status["scanStatus"] = "REQUESTED"
+		status["lastRequestTime"] = time.Now().UTC().Format(time.RFC3339)
+	case EventFindingsCollected:
+		status["scanStatus"] = "COMPLETE"
+		status["findings"] = notification.Findings
+		if notification.ScanCompletedAt != nil {
+			status["lastScanTime"] = notification.ScanCompletedAt.UTC().Format(time.RFC3339)
+		}
+	default:
+		return
+	}
+
+	object := map[string]interface{}{
+		"apiVersion": ImageScanResource.GroupVersion().String(),
+		"kind":       "ImageScan",
+		"metadata": map[string]interface{}{
+			"name":      ImageScanName(notification),
+			"namespace": n.Namespace,
+		},
+		"spec": map[string]interface{}{
+			"imageDigest": notification.ImageDigest,
+			"imageTag":    notification.ImageTag,
+			"region":      notification.Region,
+			"repository":  notification.Repository,
+		},
+		"status": status,
+	}
+	if err := n.upsert(ctx, object); err != nil {
+		n.Metrics.ImageScanWriteErrors.WithLabelValues(notification.Region).Inc()
+		logger.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to write ImageScan resource")
+		return
+	}
+	logger.Debug("wrote ImageScan resource")
+}
+
+// upsert merges the object into the existing resource, creating it if there
+// isn't one yet.
+func (n *KubernetesNotifier) upsert(ctx context.Context, object map[string]interface{}) error {
+	patch, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+
+	name := object["metadata"].(map[string]interface{})["name"].(string)
+	client := n.Client.Namespace(n.Namespace)
+	_, err = client.Patch(ctx, name, k8stypes.MergePatchType, patch, metav1.PatchOptions{
+		FieldManager: "aws-ecr-scan-operator",
+	})
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+	_, err = client.Create(ctx, &unstructured.Unstructured{Object: object}, metav1.CreateOptions{
+		FieldManager: "aws-ecr-scan-operator",
+	})
+	return err
+}
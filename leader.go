@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Leader tracks whether this replica is allowed to run scans. Without leader
+// election every replica is always the leader.
+type Leader struct {
+	Metrics *Metrics
+
+	leading atomic.Bool
+	started chan struct{}
+	once    sync.Once
+}
+
+// NewLeader creates a leader that's leading or not until told otherwise. With
+// leader election a replica only leads once it's elected.
+func NewLeader(metrics *Metrics, leading bool) *Leader {
+	leader := &Leader{Metrics: metrics, started: make(chan struct{})}
+	leader.set(leading)
+	return leader
+}
+
+// IsLeader reports whether this replica is currently the leader.
+func (l *Leader) IsLeader() bool {
+	return l.leading.Load()
+}
+
+// Started returns a channel that's closed once this replica first becomes the
+// leader.
+func (l *Leader) Started() <-chan struct{} {
+	return l.started
+}
+
+// set updates whether this replica is the leader, keeping our gauge in sync.
+func (l *Leader) set(leading bool) {
+	l.leading.Store(leading)
+	if leading {
+		l.once.Do(func() { close(l.started) })
+		l.Metrics.IsLeader.Set(1)
+	} else {
+		l.Metrics.IsLeader.Set(0)
+	}
+}
+
+// Elect takes part in leader election through a Kubernetes lease until the
+// context is done, following along with whether this replica is the leader.
+// The lease is released on shutdown so that another replica can take over
+// right away.
+func (l *Leader) Elect(ctx context.Context) error {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	// Identify ourselves by our hostname, which is the pod name in Kubernetes.
+	identity := viper.GetString("leaderelection.identity")
+	if identity == "" {
+		if identity, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      viper.GetString("leaderelection.lease_name"),
+			Namespace: Namespace("leaderelection.namespace"),
+		},
+		Client: client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	logger := log.WithFields(log.Fields{
+		"identity":  identity,
+		"lease":     lock.LeaseMeta.Name,
+		"namespace": lock.LeaseMeta.Namespace,
+	})
+	logger.Info("starting leader election")
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   viper.GetDuration("leaderelection.lease_duration"),
+		RenewDeadline:   viper.GetDuration("leaderelection.renew_deadline"),
+		RetryPeriod:     viper.GetDuration("leaderelection.retry_period"),
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				logger.Info("elected leader, running scans")
+				l.set(true)
+			},
+			OnStoppedLeading: func() {
+				logger.Info("lost leadership, no longer running scans")
+				l.set(false)
+			},
+			OnNewLeader: func(current string) {
+				logger.WithFields(log.Fields{
+					"leader": current,
+				}).Debug("observed leader")
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Losing the lease ends a round of election, so keep contending until
+	// we're shut down.
+	for ctx.Err() == nil {
+		elector.Run(ctx)
+		if ctx.Err() == nil {
+			time.Sleep(viper.GetDuration("leaderelection.retry_period"))
+		}
+	}
+	return nil
+}
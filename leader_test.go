@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewLeader(t *testing.T) {
+	for _, leading := range []bool{true, false} {
+		leader := NewLeader(NewMetrics(prometheus.NewRegistry()), leading)
+		if leader.IsLeader() != leading {
+			t.Errorf("NewLeader(%t).IsLeader() = %t", leading, leader.IsLeader())
+		}
+		want := 0.0
+		if leading {
+			want = 1
+		}
+		if got := testutil.ToFloat64(leader.Metrics.IsLeader); got != want {
+			t.Errorf("NewLeader(%t) aws_ecr_scan_is_leader = %v, want %v", leading, got, want)
+		}
+	}
+}
+
+func TestLeaderStarted(t *testing.T) {
+	leader := NewLeader(NewMetrics(prometheus.NewRegistry()), false)
+	select {
+	case <-leader.Started():
+		t.Fatal("Started() closed before leading")
+	default:
+	}
+
+	// Leading again after losing the lease doesn't start it anew.
+	leader.set(true)
+	leader.set(false)
+	leader.set(true)
+	select {
+	case <-leader.Started():
+	default:
+		t.Error("Started() not closed once leading")
+	}
+}
@@ -3,54 +3,297 @@ package main
 import (
 	"context"
 	"errors"
-	"fmt"
+	"math/rand"
 	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/procyon-projects/chrono"
 	"github.com/spf13/viper"
 
 	log "github.com/sirupsen/logrus"
 
-	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ecr"
 	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecrpublic"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-)
+	"github.com/prometheus/client_golang/prometheus/collectors"
 
-type AwsEcrClientKey struct{}
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
-var (
-	scansRequested = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "aws_ecr_scans_requested",
-		Help: "The total count of AWS ECR image scan requests sent.",
-	})
-	scanRequestErrors = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "aws_ecr_scans_requested_errors",
-		Help: "The total count of AWS ECR image scan requests that results in an error.",
-	})
-	scansRateLimited = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "aws_ecr_scans_rate_limited",
-		Help: "The total count of AWS ECR image scan requests rejected due to rate-limiting.",
-	})
+	"golang.org/x/time/rate"
 )
 
+// ConfigDirectory is where a configuration file is looked for when none is
+// explicitly given.
+const ConfigDirectory = "/etc/aws-ecr-scan-operator"
+
+// sensitiveKeys are the configuration elements holding secrets, which must
+// never be logged.
+var sensitiveKeys = []string{
+	"notifications.slack.webhook_url",
+	"notifications.webhook.headers",
+	"notifications.webhook.url",
+	"web.auth.bearer_token",
+	"web.trigger_token",
+}
+
+// accountIDPattern matches a valid AWS account ID.
+var accountIDPattern = regexp.MustCompile(`^[0-9]{12}$`)
+
+// ECRAPI is the subset of the AWS ECR API used by the operator, satisfied by
+// *ecr.Client and substitutable with a mock for testing.
+type ECRAPI interface {
+	BatchGetImage(context.Context, *ecr.BatchGetImageInput, ...func(*ecr.Options)) (*ecr.BatchGetImageOutput, error)
+	DescribeImages(context.Context, *ecr.DescribeImagesInput, ...func(*ecr.Options)) (*ecr.DescribeImagesOutput, error)
+	DescribeImageScanFindings(context.Context, *ecr.DescribeImageScanFindingsInput, ...func(*ecr.Options)) (*ecr.DescribeImageScanFindingsOutput, error)
+	DescribeRepositories(context.Context, *ecr.DescribeRepositoriesInput, ...func(*ecr.Options)) (*ecr.DescribeRepositoriesOutput, error)
+	GetRegistryScanningConfiguration(context.Context, *ecr.GetRegistryScanningConfigurationInput, ...func(*ecr.Options)) (*ecr.GetRegistryScanningConfigurationOutput, error)
+	ListImages(context.Context, *ecr.ListImagesInput, ...func(*ecr.Options)) (*ecr.ListImagesOutput, error)
+	ListTagsForResource(context.Context, *ecr.ListTagsForResourceInput, ...func(*ecr.Options)) (*ecr.ListTagsForResourceOutput, error)
+	PutRegistryScanningConfiguration(context.Context, *ecr.PutRegistryScanningConfigurationInput, ...func(*ecr.Options)) (*ecr.PutRegistryScanningConfigurationOutput, error)
+	StartImageScan(context.Context, *ecr.StartImageScanInput, ...func(*ecr.Options)) (*ecr.StartImageScanOutput, error)
+}
+
+// Registry is a single AWS ECR registry to reconcile, along with the details
+// needed to identify it in logs and metrics.
+type Registry struct {
+	Client ECRAPI
+	Region string
+
+	// Profile is the named AWS profile the registry is reconciled through, or
+	// empty for the profile resolved by the default AWS configuration chain.
+	Profile string
+
+	// Metrics are where the reconciliation of the registry is recorded.
+	Metrics *Metrics
+
+	// Logger is what every log of the registry's reconciliation derives from,
+	// carrying the ID of the run reconciling it if any.
+	Logger *log.Entry
+
+	// ID is the AWS account ID of the registry, or nil for the registry of the
+	// account we're authenticated as.
+	ID *string
+
+	// ContinuousScanFilters are those of the registry-wide scanning
+	// configuration, used to skip repositories that are already scanned
+	// continuously.
+	ContinuousScanFilters ContinuousScanFilters
+
+	// Notifiers are notified of the scans requested against the registry.
+	Notifiers []Notifier
+
+	// State remembers when scans were last requested, or nil if we rely on
+	// AWS ECR alone.
+	State StateStore
+
+	// Tags caches the resource tags of repositories throughout the run.
+	Tags *TagCache
+
+	// Budget caps the number of scans requested throughout the run, or nil if
+	// it's unlimited.
+	Budget *ScanBudget
+
+	// Queue is where scan tasks are enqueued in the producer role, rather than
+	// requesting the scans ourselves, or nil otherwise.
+	Queue *WorkQueue
+
+	// Limiter paces the scan requests against the registry, shared with every
+	// other registry of the run, or nil if they aren't paced.
+	Limiter *rate.Limiter
+
+	// Deferred retries the scans of rate-limited images once they're eligible
+	// again, or nil if they're left to the next run.
+	Deferred *DeferredScans
+
+	// Health tracks our readiness, marked ready once the registry's
+	// repositories are described, or nil.
+	Health *Health
+
+	// FindingsPool bounds how many images have their scan findings described
+	// at once, shared with every other registry of the run, or nil if they're
+	// described one at a time.
+	FindingsPool *WorkerPool
+
+	// FindingsLimiter paces the requests for scan findings much like Limiter,
+	// or nil if they aren't paced.
+	FindingsLimiter *rate.Limiter
+
+	// Findings collects the findings of the repositories whose scans we
+	// requested on a schedule of its own, if at all.
+	Findings *FindingsCollector
+
+	// Safety aborts the run once it enumerates more images than it should,
+	// shared with every other registry of the run, or nil to never abort.
+	Safety *SafetyLimit
+}
+
 func main() {
-	// Establish our configuration default values.
+	// Our commands report their own usage errors.
+	if err := NewCommand().Execute(); err != nil {
+		Exit(ExitInvalidConfig)
+	}
+}
+
+// SetDefaults establishes the default values of our configuration.
+func SetDefaults() {
+	viper.SetDefault("audit.actor", "")
+	viper.SetDefault("audit.enabled", false)
+	viper.SetDefault("audit.file", "")
+	viper.SetDefault("aws.endpoint_url", "")
+	viper.SetDefault("aws.http_timeout", time.Duration(0))
+	viper.SetDefault("aws.max_retries", 3)
+	viper.SetDefault("aws.public_registry", false)
+	viper.SetDefault("aws.skip_preflight", false)
 	viper.SetDefault("log.format", "logfmt")
 	viper.SetDefault("log.level", "info")
+	viper.SetDefault("log.per_image", true)
+	viper.SetDefault("log.sample_rate", 1.0)
+	viper.SetDefault("log.unsupported_once", false)
+	viper.SetDefault("mode", "daemon")
+	viper.SetDefault("cron.enabled", true)
+	viper.SetDefault("cron.run_on_startup", false)
 	viper.SetDefault("cron.schedule", "0 0 0 * * *")
+	viper.SetDefault("cron.jitter", time.Duration(0))
+	viper.SetDefault("cron.jitter_seed", 0)
+	viper.SetDefault("debug.pprof.enabled", false)
+	viper.SetDefault("findings.concurrency", 5)
+	viper.SetDefault("findings.enabled", false)
+	viper.SetDefault("findings.lookback", time.Hour)
+	viper.SetDefault("findings.rate_limit", 0)
+	viper.SetDefault("findings.schedule", "0 */15 * * * *")
+	viper.SetDefault("images.digest_allowlist", []string{})
+	viper.SetDefault("images.digest_allowlist_file", "")
+	viper.SetDefault("images.digest_denylist", []string{})
+	viper.SetDefault("images.digest_denylist_file", "")
+	viper.SetDefault("images.expand_manifest_lists", false)
 	viper.SetDefault("images.filter.tag.status", "any")
+	viper.SetDefault("images.latest_tag", "latest")
+	viper.SetDefault("images.max_age", time.Duration(0))
+	viper.SetDefault("images.max_per_repository", 0)
+	viper.SetDefault("images.only_latest_on_mutable", false)
+	viper.SetDefault("images.platforms", []string{})
+	viper.SetDefault("images.semver_latest", false)
+	viper.SetDefault("images.semver_latest_other", SemverOtherScan)
+	viper.SetDefault("images.skip_untagged", false)
+	viper.SetDefault("images.tag_include", []string{})
+	viper.SetDefault("images.tag_exclude", []string{})
+	viper.SetDefault("work.role", WorkRoleStandalone)
+	viper.SetDefault("work.sqs.max_messages", 10)
+	viper.SetDefault("work.sqs.queue_url", "")
+	viper.SetDefault("work.sqs.retry_delay", time.Minute)
+	viper.SetDefault("work.sqs.visibility_timeout", 5*time.Minute)
+	viper.SetDefault("work.sqs.wait_time", 20*time.Second)
+	viper.SetDefault("web.auth.bearer_token", "")
+	viper.SetDefault("web.base_path", "")
+	viper.SetDefault("web.enabled", true)
 	viper.SetDefault("web.host", "0.0.0.0")
+	viper.SetDefault("web.idle_timeout", time.Minute)
 	viper.SetDefault("web.port", 9090)
+	viper.SetDefault("web.health_path", "/healthz")
+	viper.SetDefault("web.read_timeout", 10*time.Second)
+	viper.SetDefault("web.ready_path", "/readyz")
+	viper.SetDefault("web.ready_failure_threshold", 3)
+	viper.SetDefault("web.tls.cert", "")
+	viper.SetDefault("web.tls.key", "")
+	viper.SetDefault("web.trigger_path", "/trigger")
+	viper.SetDefault("web.trigger_token", "")
+	viper.SetDefault("web.write_timeout", time.Minute)
+	viper.SetDefault("kubernetes.enabled", false)
+	viper.SetDefault("kubernetes.namespace", "")
+	viper.SetDefault("leaderelection.enabled", false)
+	viper.SetDefault("leaderelection.identity", "")
+	viper.SetDefault("leaderelection.lease_name", "aws-ecr-scan-operator")
+	viper.SetDefault("leaderelection.lease_duration", 15*time.Second)
+	viper.SetDefault("leaderelection.namespace", "")
+	viper.SetDefault("leaderelection.renew_deadline", 10*time.Second)
+	viper.SetDefault("leaderelection.retry_period", 2*time.Second)
+	viper.SetDefault("metrics.namespace", "")
 	viper.SetDefault("metrics.path", "/metrics")
+	viper.SetDefault("metrics.repository_label", true)
+	viper.SetDefault("metrics.subsystem", "")
+	viper.SetDefault("notifications.eventbridge.enabled", false)
+	viper.SetDefault("notifications.eventbridge.bus_name", "default")
+	viper.SetDefault("notifications.slack.min_interval", time.Hour)
+	viper.SetDefault("notifications.webhook.base_delay", time.Second)
+	viper.SetDefault("notifications.webhook.events", []string{EventRunFinished})
+	viper.SetDefault("notifications.webhook.headers", map[string]string{})
+	viper.SetDefault("notifications.webhook.max_attempts", 3)
+	viper.SetDefault("notifications.webhook.queue_size", 1000)
+	viper.SetDefault("notifications.webhook.template", "")
+	viper.SetDefault("notifications.webhook.timeout", 10*time.Second)
+	viper.SetDefault("notifications.webhook.url", "")
+	viper.SetDefault("output.jsonl.enabled", false)
+	viper.SetDefault("output.jsonl.path", "")
+	viper.SetDefault("repositories.include", []string{})
+	viper.SetDefault("repositories.names", []string{})
+	viper.SetDefault("repositories.select_by_tag", "")
+	viper.SetDefault("repositories.exclude", []string{})
+	viper.SetDefault("repositories.from_file", "")
+	viper.SetDefault("repositories.from_file_fallback", RepositoryFileFallbackNone)
+	viper.SetDefault("run.failure_policy", FailurePolicyIgnore)
+	viper.SetDefault("run.region_failure_policy", RegionFailurePolicyFail)
+	viper.SetDefault("safety.max_total_images", 100000)
+	viper.SetDefault("scan.concurrency", 10)
+	viper.SetDefault("scan.image_concurrency", 0)
+	viper.SetDefault("scan.repository_concurrency", 5)
+	viper.SetDefault("state.backend", StateBackendNone)
+	viper.SetDefault("state.dynamodb.table", "")
+	viper.SetDefault("scan.desired_configuration.enabled", false)
+	viper.SetDefault("scan.desired_configuration.scan_type", string(types.ScanTypeBasic))
+	viper.SetDefault("scan.dry_run", false)
+	viper.SetDefault("scan.fail_threshold", "")
+	viper.SetDefault("scan.max_per_run", 0)
+	viper.SetDefault("scan.min_interval", 24*time.Hour)
+	viper.SetDefault("scan.retry.max_attempts", 3)
+	viper.SetDefault("scan.retry_limit_exceeded_after", time.Duration(0))
+	viper.SetDefault("scan.rate_burst", 1)
+	viper.SetDefault("scan.rate_limit", 0.0)
+	viper.SetDefault("scan.incremental", false)
+	viper.SetDefault("scan.order", ScanOrderNone)
+	viper.SetDefault("scan.rate_limited_warn_ratio", 0.5)
+	viper.SetDefault("scan.repository_timeout", time.Duration(0))
+	viper.SetDefault("scan.run_timeout", 10*time.Minute)
+	viper.SetDefault("scan.severities", []string{})
+	viper.SetDefault("scan.status_filter.exclude", []string{})
+	viper.SetDefault("scan.status_filter.include", []string{})
+	viper.SetDefault("scan.skip_scan_on_push", false)
+	viper.SetDefault("scan.retry.base_delay", time.Second)
+	viper.SetDefault("startup.delay", time.Duration(0))
+	viper.SetDefault("startup.retry.base_delay", time.Second)
+	viper.SetDefault("startup.retry.max_attempts", 5)
+}
+
+// Operate runs the given command of the operator, once its command-line flags
+// are bound to our configuration.
+func Operate(command string) {
+	// Establish our configuration default values, which the environment
+	// overrides.
+	SetDefaults()
 	viper.SetEnvPrefix("AWS_ECR_SCAN")
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
 
+	// Load our configuration file, if there is one, underneath the environment.
+	// An explicitly given file must exist, otherwise we look for one in the
+	// default location.
+	if file := viper.GetString("config"); file != "" {
+		viper.SetConfigFile(file)
+	} else {
+		viper.SetConfigName("config")
+		viper.AddConfigPath(ConfigDirectory)
+	}
+	configErr := viper.ReadInConfig()
+
 	// Setup our logging format before we output any log messages.
 	switch viper.GetString("log.format") {
 	case "json":
@@ -72,92 +315,1282 @@ func main() {
 	log.SetLevel(level)
 	log.Debug("logging initialized")
 
+	// Create our metrics now that we know what to name them, rejecting prefixes
+	// Prometheus won't accept in a metric name.
+	for _, key := range []string{"metrics.namespace", "metrics.subsystem"} {
+		if value := viper.GetString(key); value != "" && !metricNamePrefixPattern.MatchString(value) {
+			Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+				"key":   key,
+				"value": value,
+			}), "invalid metric name prefix")
+		}
+	}
+	// Our metrics have a registry of their own, alongside the standard Golang
+	// and process metrics.
+	gatherer := prometheus.NewRegistry()
+	gatherer.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	metrics := NewMetrics(gatherer)
+
+	// Make it known which build of the operator is running.
+	log.WithFields(log.Fields{
+		"build": RecordBuildInfo(metrics),
+	}).Info("starting aws-ecr-scan-operator")
+
+	// Make it known where our configuration came from.
+	var notFound viper.ConfigFileNotFoundError
+	switch {
+	case errors.As(configErr, &notFound):
+		log.WithFields(log.Fields{
+			"directory": ConfigDirectory,
+		}).Info("no configuration file found")
+	case configErr != nil:
+		Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+			"err":  configErr,
+			"file": viper.ConfigFileUsed(),
+		}), "failed to read configuration file")
+	default:
+		log.WithFields(log.Fields{
+			"file": viper.ConfigFileUsed(),
+		}).Info("loaded configuration file")
+	}
+
 	// Output the service's configuration in case we need to see it.
 	// NOTE: this should never include any sensitive information or secrets.
 	log.WithFields(log.Fields{
-		"config": viper.AllSettings(),
+		"config": RedactedSettings(),
 	}).Info("reconciled configuration")
 
-	// Establish our cron scheduler.
-	log.Debug("initializing chrono scheduler")
-	scheduler := chrono.NewDefaultTaskScheduler()
-	_, err = scheduler.ScheduleWithCron(TriggerScans, viper.GetString("cron.schedule"))
-	if err != nil {
+	// Scanning the images given on the command line is a one-shot run of its
+	// own, which needs both the repository and its images.
+	targeted, refs := TargetedImages()
+	if (targeted == "") != (len(refs) == 0) {
+		Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+			"images":     refs,
+			"repository": targeted,
+		}), "both or neither of --repository and --image must be given")
+	}
+
+	// Validate our cron schedule before starting anything else, as a broken
+	// schedule means the operator would never do anything at all.
+	oneshot := viper.GetBool("once") || viper.GetString("mode") == "oneshot" || targeted != ""
+	schedule := viper.GetString("cron.schedule")
+	if viper.GetBool("cron.enabled") && !oneshot {
+		expression, err := chrono.ParseCronExpression(schedule)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":      err,
+				"schedule": schedule,
+			}).Errorf(
+				"invalid cron schedule %q, expected six space-separated fields of "+
+					"second, minute, hour, day of month, month and day of week (e.g. \"0 0 0 * * *\")",
+				schedule,
+			)
+			Exit(ExitInvalidSchedule)
+		}
+
+		// Show when the next few runs will be so it's easy to confirm the
+		// schedule does what's expected.
+		var next []time.Time
+		t := time.Now()
+		for i := 0; i < 3; i++ {
+			t = expression.NextTime(t)
+			next = append(next, t)
+		}
 		log.WithFields(log.Fields{
-			"err": err,
-		}).Fatal("failed to initialize chrono scheduler")
+			"next":     next,
+			"schedule": schedule,
+		}).Info("validated cron schedule")
+
+		// AWS ECR only allows a single scan of each image a day, so any more
+		// frequent schedule mostly gets rate-limited unless it's told to skip
+		// the images scanned within the day.
+		interval := ScheduleInterval(expression, time.Now())
+		if minInterval := viper.GetDuration("scan.min_interval"); interval > 0 && interval < scanQuotaWindow && minInterval < scanQuotaWindow {
+			log.WithFields(log.Fields{
+				"interval":     interval,
+				"min_interval": minInterval,
+				"schedule":     schedule,
+			}).Warn("cron schedule runs more often than AWS ECR allows images to be scanned, most scan requests will be rate-limited unless scan.min_interval is at least 24h")
+		}
+
+		// Likewise for the schedule of collecting the findings of recent scans.
+		if findings := viper.GetString("findings.schedule"); viper.GetBool("findings.enabled") && findings != "" {
+			if _, err := chrono.ParseCronExpression(findings); err != nil {
+				Fatal(ExitInvalidSchedule, log.WithFields(log.Fields{
+					"err":      err,
+					"schedule": findings,
+				}), "invalid findings schedule, expected six space-separated fields like cron.schedule")
+			}
+		}
 	}
 
-	// Add our Prometheus metrics handler.
-	log.Debug("adding Prometheus metrics handler")
-	http.Handle(viper.GetString("metrics.path"), promhttp.Handler())
+	// Registry IDs are AWS account IDs, anything else is almost certainly a
+	// mistake that AWS ECR will reject.
+	if id := viper.GetString("aws.registry_id"); id != "" && !accountIDPattern.MatchString(id) {
+		log.WithFields(log.Fields{
+			"registry": id,
+		}).Warn("registry ID is not a 12-digit AWS account ID")
+	}
 
-	// Start our webserver.
-	log.Debug("starting webserver")
-	err = http.ListenAndServe(
-		fmt.Sprintf(
-			"%s:%d",
-			viper.GetString("web.host"),
-			viper.GetInt32("web.port"),
-		),
-		nil,
-	)
-	if err != nil {
+	// Each profile labels the metrics of its registries, so they must be both
+	// named and distinct.
+	profiles := map[string]bool{}
+	for _, profile := range viper.GetStringSlice("aws.profiles") {
+		if profile == "" || profiles[profile] {
+			Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+				"profiles": viper.GetStringSlice("aws.profiles"),
+			}), "AWS profiles must be non-empty and distinct")
+		}
+		profiles[profile] = true
+	}
+
+	// The rate-limited ratio a run is flagged at is a proportion of its scans.
+	if ratio := viper.GetFloat64("scan.rate_limited_warn_ratio"); ratio < 0 || ratio > 1 {
+		Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+			"ratio": ratio,
+		}), "rate-limited warning ratio must be between 0 and 1")
+	}
+
+	// Any shared configuration and credentials files given in place of those in
+	// ~/.aws must exist, as the AWS SDK silently skips missing ones.
+	for _, key := range []string{"aws.config_files", "aws.credentials_files"} {
+		for _, file := range viper.GetStringSlice(key) {
+			if _, err := os.Stat(file); err != nil {
+				Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+					"err":  err,
+					"file": file,
+				}), "invalid AWS shared configuration file")
+			}
+		}
+	}
+	if len(viper.GetStringSlice("aws.config_files")) > 0 || len(viper.GetStringSlice("aws.credentials_files")) > 0 {
+		log.WithFields(log.Fields{
+			"config":      viper.GetStringSlice("aws.config_files"),
+			"credentials": viper.GetStringSlice("aws.credentials_files"),
+		}).Info("using AWS shared configuration files")
+	} else {
+		log.Debug("using the default AWS shared configuration files")
+	}
+
+	// Create the worker pool all image scan requests are funneled through, this
+	// keeps us from overwhelming the AWS ECR API on large registries. The image
+	// concurrency supersedes the scan concurrency it was once known as.
+	concurrency := viper.GetInt("scan.image_concurrency")
+	if concurrency == 0 {
+		concurrency = viper.GetInt("scan.concurrency")
+	}
+	if concurrency < 1 {
 		log.WithFields(log.Fields{
+			"concurrency": concurrency,
+		}).Warn("invalid scan concurrency, defaulting to 10")
+		concurrency = 10
+	}
+	pool := NewWorkerPool(concurrency)
+
+	// Ensure our fail threshold is a known severity, silently not gating on it
+	// would defeat the purpose.
+	if threshold := viper.GetString("scan.fail_threshold"); threshold != "" {
+		if _, err := ParseSeverity(threshold); err != nil {
+			Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+				"err":       err,
+				"threshold": threshold,
+			}), "invalid scan fail threshold")
+		}
+	}
+
+	// Ensure our failure policy is known, as it decides whether we keep running.
+	policy := viper.GetString("run.failure_policy")
+	switch policy {
+	case FailurePolicyIgnore, FailurePolicyWarn, FailurePolicyCrash:
+	default:
+		Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+			"policy": policy,
+		}), "invalid run failure policy, expected ignore, warn or crash")
+	}
+
+	// Likewise for the policy on regions failing.
+	switch regionPolicy := viper.GetString("run.region_failure_policy"); regionPolicy {
+	case RegionFailurePolicyFail, RegionFailurePolicyIgnore:
+	default:
+		Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+			"policy": regionPolicy,
+		}), "invalid run region failure policy, expected fail or ignore")
+	}
+
+	// Ensure our desired registry scanning configuration can be understood
+	// before we set about changing registries to it.
+	if viper.GetBool("scan.desired_configuration.enabled") {
+		if _, err := DesiredScanningConfiguration(); err != nil {
+			Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+				"err": err,
+			}), "invalid desired registry scanning configuration")
+		}
+	}
+
+	// Serving over HTTPS takes both a certificate and its key.
+	if (viper.GetString("web.tls.cert") == "") != (viper.GetString("web.tls.key") == "") {
+		Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+			"cert": viper.GetString("web.tls.cert"),
+			"key":  viper.GetString("web.tls.key"),
+		}), "both or neither of the webserver TLS certificate and key must be set")
+	}
+
+	// Ensure our repository tag selector can be understood.
+	if selector := viper.GetString("repositories.select_by_tag"); selector != "" {
+		if _, _, err := ParseTagSelector(selector); err != nil {
+			Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+				"err": err,
+			}), "invalid repository tag selector")
+		}
+	}
+
+	// Likewise the order to request the scans of each repository's images in.
+	switch order := viper.GetString("scan.order"); order {
+	case ScanOrderNone, ScanOrderNewest, ScanOrderOldest, ScanOrderRandom, ScanOrderName:
+	default:
+		Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+			"order": order,
+		}), "invalid scan order, expected none, newest, oldest, random or name")
+	}
+
+	// Likewise what to do with the images whose tags aren't semantic versions.
+	switch other := viper.GetString("images.semver_latest_other"); other {
+	case SemverOtherScan, SemverOtherSkip:
+	default:
+		Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+			"other": other,
+		}), "invalid handling of non-semver tags, expected scan or skip")
+	}
+
+	// Likewise what to do without any repositories listed in their file.
+	switch fallback := viper.GetString("repositories.from_file_fallback"); fallback {
+	case RepositoryFileFallbackAll, RepositoryFileFallbackNone:
+	default:
+		Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+			"fallback": fallback,
+		}), "invalid repositories file fallback, expected all or none")
+	}
+
+	// Likewise the platforms to scan the images of manifest lists for.
+	if _, err := SelectedPlatforms(); err != nil {
+		Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+			"err":       err,
+			"platforms": viper.GetStringSlice("images.platforms"),
+		}), "invalid image platforms")
+	}
+
+	// Likewise the regular expressions among the repository and tag patterns,
+	// compiling them once for every name matched against them.
+	for _, key := range []string{
+		"images.tag_exclude",
+		"images.tag_include",
+		"repositories.exclude",
+		"repositories.include",
+	} {
+		if err := ValidatePatterns(viper.GetStringSlice(key)); err != nil {
+			Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+				"err": err,
+				"key": key,
+			}), "invalid patterns")
+		}
+	}
+
+	// Likewise the overrides of repository settings.
+	if _, err := RepositoryOverrides(); err != nil {
+		Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
 			"err": err,
-		}).Fatal("webserver failed")
+		}), "invalid repository overrides")
 	}
-}
 
-func TriggerScans(ctx context.Context) {
-	// Reconcile our AWS client configuration.
-	log.Debug("loading AWS configuration")
-	cfg, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
+	// Likewise the scan statuses to filter images by.
+	for _, key := range []string{"scan.status_filter.include", "scan.status_filter.exclude"} {
+		if _, err := ParseScanStatuses(viper.GetStringSlice(key)); err != nil {
+			Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+				"err":      err,
+				"statuses": viper.GetStringSlice(key),
+			}), "invalid scan status filter")
+		}
+	}
+
+	// Likewise reject unknown severities to count findings of.
+	if _, err := ParseSeverities(viper.GetStringSlice("scan.severities")); err != nil {
+		Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+			"err":        err,
+			"severities": viper.GetStringSlice("scan.severities"),
+		}), "invalid scan severities")
+	}
+
+	// Likewise where we remember the scans we've requested.
+	switch backend := viper.GetString("state.backend"); backend {
+	case StateBackendNone, StateBackendMemory:
+	case StateBackendDynamoDB:
+		if viper.GetString("state.dynamodb.table") == "" {
+			Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+				"backend": backend,
+			}), "no AWS DynamoDB table configured for the scan state")
+		}
+	default:
+		Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+			"backend": backend,
+		}), "invalid state backend, expected none, memory or dynamodb")
+	}
+
+	// Incremental mode keeps its watermarks in the state store.
+	if viper.GetBool("scan.incremental") && viper.GetString("state.backend") == StateBackendNone {
+		Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+			"backend": viper.GetString("state.backend"),
+		}), "incremental mode requires a state backend")
+	}
+
+	// Likewise our role in distributing scans across instances.
+	switch role := viper.GetString("work.role"); role {
+	case WorkRoleStandalone:
+	case WorkRoleProducer, WorkRoleWorker:
+		if viper.GetString("work.sqs.queue_url") == "" {
+			Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+				"role": role,
+			}), "no AWS SQS queue configured for the work role")
+		}
+		if role == WorkRoleWorker && oneshot {
+			Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+				"role": role,
+			}), "the worker role can't run in one-shot mode")
+		}
+	default:
+		Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+			"role": role,
+		}), "invalid work role, expected standalone, producer or worker")
+	}
+
+	// That's all there is to validating our configuration, short of loading
+	// our AWS configuration and credentials.
+	if command == CommandValidate {
+		log.Info("configuration is valid")
+		return
+	}
+
+	// Run until we're asked to shut down, which may well be before we've even
+	// finished starting up.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Hold off on starting if asked to, so that replicas rolled out together
+	// don't all hit the AWS credential providers at once.
+	if delay := viper.GetDuration("startup.delay"); delay > 0 {
 		log.WithFields(log.Fields{
+			"delay": delay,
+		}).Info("delaying startup")
+		select {
+		case <-ctx.Done():
+			log.Info("shut down during startup delay")
+			Exit(0)
+		case <-time.After(delay):
+		}
+	}
+
+	// Setup where we remember the scans we've requested, if anywhere.
+	var state StateStore
+	switch backend := viper.GetString("state.backend"); backend {
+	case StateBackendNone:
+	case StateBackendMemory:
+		state = NewMemoryStateStore()
+	case StateBackendDynamoDB:
+		cfg, err := LoadStartupAWSConfig(ctx)
+		if err != nil {
+			Fatal(ExitAWSError, log.WithFields(log.Fields{
+				"err": err,
+			}), "failed to load AWS configuration")
+		}
+		state = NewDynamoDBStateStore(cfg, viper.GetString("state.dynamodb.table"))
+	}
+
+	// Setup our work queue if we're distributing scans across instances.
+	var queue *WorkQueue
+	role := viper.GetString("work.role")
+	if role == WorkRoleProducer || role == WorkRoleWorker {
+		cfg, err := LoadStartupAWSConfig(ctx)
+		if err != nil {
+			Fatal(ExitAWSError, log.WithFields(log.Fields{
+				"err": err,
+			}), "failed to load AWS configuration")
+		}
+		queue = NewWorkQueue(cfg, viper.GetString("work.sqs.queue_url"))
+	}
+
+	// We're ready as soon as we've shown we can reach AWS, whether by passing
+	// our preflight check or describing the repositories of a first run.
+	health := &Health{
+		Policy:    policy,
+		Threshold: viper.GetInt64("web.ready_failure_threshold"),
+	}
+
+	// Check our AWS credentials and permissions right away, rather than have
+	// them fail the first run which may be hours away. Workers only request
+	// scans, as do targeted scans of specific images, so there are no
+	// repositories for them to check.
+	if !viper.GetBool("aws.skip_preflight") {
+		for _, profile := range Profiles() {
+			for _, region := range Regions() {
+				cfg, err := LoadStartupAWSConfig(ctx, AWSConfigOptions(profile, region)...)
+				if err != nil {
+					Fatal(ExitAWSError, log.WithFields(log.Fields{
+						"err":     err,
+						"profile": profile,
+						"region":  region,
+					}), "failed to load AWS configuration")
+				}
+				check, cancel := context.WithTimeout(ctx, 30*time.Second)
+				caller, err := Preflight(check, cfg, role != WorkRoleWorker && targeted == "")
+				cancel()
+				if err != nil {
+					Fatal(ExitAWSError, log.WithFields(log.Fields{
+						"err":     err,
+						"profile": profile,
+						"region":  cfg.Region,
+					}), "AWS preflight check failed, set aws.skip_preflight to skip it")
+				}
+				log.WithFields(log.Fields{
+					"caller":  caller,
+					"profile": profile,
+					"region":  cfg.Region,
+				}).Info("AWS preflight check passed")
+			}
+		}
+		health.MarkReady("preflight")
+	}
+
+	// Setup our audit log of scan requests if asked to.
+	if err := SetupAuditLog(metrics); err != nil {
+		Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+			"err":  err,
+			"file": viper.GetString("audit.file"),
+		}), "failed to open audit log")
+	}
+
+	// Likewise our output of findings as JSON Lines.
+	if err := SetupFindingsLines(metrics); err != nil {
+		Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
+			"err":  err,
+			"file": viper.GetString("output.jsonl.path"),
+		}), "failed to open findings output")
+	}
+
+	// Setup our run summaries if we have anywhere to deliver them to.
+	var summaries []RunNotifier
+	if url := viper.GetString("notifications.slack.webhook_url"); url != "" {
+		summaries = append(summaries, NewSlackNotifier(
+			metrics,
+			url,
+			viper.GetDuration("notifications.slack.min_interval"),
+		))
+	}
+	if err := SetupWebhook(metrics); err != nil {
+		Fatal(ExitInvalidConfig, log.WithFields(log.Fields{
 			"err": err,
-		}).Fatal("failed to load AWS configuration")
+		}), "invalid webhook configuration")
+	}
+	if webhook != nil {
+		summaries = append(summaries, webhook)
 	}
 
-	// Create our AWS client object to be injected into the context.
-	log.Debug("creating AWS ECR client")
-	client := ecr.NewFromConfig(cfg)
+	// Setup our tracing if there's anywhere to export spans to, flushing any
+	// remaining spans before we exit however we exit.
+	shutdownTracing := func(context.Context) error { return nil }
+	if TracingEnabled() {
+		shutdownTracing, err = SetupTracing(context.Background())
+		if err != nil {
+			Fatal(ExitInternalError, log.WithFields(log.Fields{
+				"err": err,
+			}), "failed to initialize tracing")
+		}
+	}
+	OnExit(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Warn("failed to flush traces")
+		}
+	})
 
-	// Create a new context with the AWS ECR client object injected into it.
-	ecrctx := context.WithValue(ctx, AwsEcrClientKey{}, client)
+	// In one-shot mode we run a single scan synchronously and exit without
+	// starting the scheduler or the webserver.
+	if oneshot {
+		log.Info("running a single scan")
+		result := TriggerScans(ctx, metrics, pool, state, queue, nil, nil, summaries, nil)
+		if result.Failures > 0 {
+			log.WithFields(log.Fields{
+				"failures": result.Failures,
+			}).Error("scan finished with failures")
+			Exit(ExitRunFailed)
+		}
+		if result.OverThreshold > 0 {
+			log.WithFields(log.Fields{
+				"images":    result.OverThreshold,
+				"threshold": viper.GetString("scan.fail_threshold"),
+			}).Error("scan found images with findings at or above the fail threshold")
+			Exit(ExitOverThreshold)
+		}
+		log.Info("scan finished successfully")
+		Cleanup()
+		return
+	}
 
-	// Create a paginator (TODO)
-	log.Debug("describing AWS ECR repositories")
-	paginator := ecr.NewDescribeRepositoriesPaginator(
-		client,
-		&ecr.DescribeRepositoriesInput{},
-	)
+	// When running multiple replicas only the elected leader runs scans, the
+	// rest stand by to take over.
+	leader := NewLeader(metrics, !viper.GetBool("leaderelection.enabled"))
+	elected := make(chan struct{})
+	if viper.GetBool("leaderelection.enabled") {
+		go func() {
+			err := leader.Elect(ctx)
+			close(elected)
+			if err != nil {
+				Fatal(ExitInternalError, log.WithFields(log.Fields{
+					"err": err,
+				}), "leader election failed")
+			}
+		}()
+	} else {
+		close(elected)
+	}
 
-	// While we still have pages in the DescribeRepositories response, take a page and
-	// pass each repository off.
-	for paginator.HasMorePages() {
-		response, err := paginator.NextPage(ctx)
+	// Give up our lease on the way out so another replica can take over right
+	// away.
+	OnExit(func() {
+		stop()
+		<-elected
+	})
+
+	// Establish our cron scheduler, recording the result of each run so that our
+	// readiness reflects it.
+	health.Leader = leader
+	// Workers only consume the scan tasks their producers enqueue, they have no
+	// runs of their own to schedule or trigger.
+	var runner *Runner
+	if role == WorkRoleWorker {
+		worker := &Worker{
+			Health:  health,
+			Metrics: metrics,
+			Pool:    pool,
+			Queue:   queue,
+			State:   state,
+		}
+		go worker.Run(ctx)
+	} else {
+		// Collect the findings of recent scans between runs if we're
+		// exporting them and have a schedule to do so on.
+		var findings *FindingsCollector
+		if viper.GetBool("findings.enabled") && viper.GetBool("cron.enabled") && viper.GetString("findings.schedule") != "" {
+			findings = NewFindingsCollector(viper.GetDuration("findings.lookback"))
+		}
+		runner = &Runner{
+			Context: ctx,
+			Deferred: NewDeferredScans(
+				ctx,
+				pool,
+				leader,
+				viper.GetDuration("scan.retry_limit_exceeded_after"),
+			),
+			Findings:  findings,
+			Health:    health,
+			Leader:    leader,
+			Metrics:   metrics,
+			Pool:      pool,
+			Queue:     queue,
+			State:     state,
+			Summaries: summaries,
+		}
+	}
+	if runner != nil && viper.GetBool("cron.enabled") {
+		// Seed our jitter from the clock unless a seed is given.
+		seed := viper.GetInt64("cron.jitter_seed")
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		jitter := NewJitter(viper.GetDuration("cron.jitter"), seed)
+
+		log.Debug("initializing chrono scheduler")
+		health.Scheduler = chrono.NewDefaultTaskScheduler()
+		_, err = health.Scheduler.ScheduleWithCron(func(ctx context.Context) {
+			if !leader.IsLeader() {
+				log.Debug("not the leader, skipping scheduled run")
+				return
+			}
+
+			// Only a single run may be in progress at any time, if a run is
+			// still going when the next tick fires the tick is queued behind
+			// it, and skipped if a run is already queued.
+			if runner.Run(ctx, jitter.Delay(), TriggerSchedule) == TriggerCoalesced {
+				log.Warn("run already queued, skipping scheduled run")
+				metrics.RunOverlapsSkipped.Inc()
+			}
+		}, schedule)
+		if err != nil {
+			Fatal(ExitInternalError, log.WithFields(log.Fields{
+				"err": err,
+			}), "failed to initialize chrono scheduler")
+		}
+
+		// Collecting the findings of recent scans is a task of its own, only
+		// guarded against overlapping with itself rather than with our runs.
+		if runner.Findings != nil {
+			_, err = health.Scheduler.ScheduleWithCron(func(ctx context.Context) {
+				if !leader.IsLeader() {
+					log.Debug("not the leader, skipping findings collection")
+					return
+				}
+				if !runner.Findings.Collect(ctx) {
+					log.Warn("previous findings collection still in progress, skipping")
+				}
+			}, viper.GetString("findings.schedule"))
+			if err != nil {
+				Fatal(ExitInternalError, log.WithFields(log.Fields{
+					"err": err,
+				}), "failed to schedule findings collection")
+			}
+		}
+
+		// Run right away rather than sitting idle until the first tick if
+		// asked to, which is still subject to the same queue. With leader
+		// election that's once this replica is first elected, if ever.
+		if viper.GetBool("cron.run_on_startup") {
+			go func() {
+				select {
+				case <-leader.Started():
+				case <-ctx.Done():
+					return
+				}
+				log.Info("running on startup")
+				if runner.Run(ctx, 0, TriggerStartup) == TriggerCoalesced {
+					log.Warn("run already queued, skipping startup run")
+				}
+			}()
+		}
+	}
+
+	// Without a webserver there's nothing left to do but let the scheduler work.
+	// Our metrics are still recorded, they just can't be scraped.
+	if viper.GetBool("web.enabled") {
+		server := NewServer(gatherer, health, runner)
+		OnExit(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := server.Shutdown(ctx); err != nil {
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Warn("failed to shut down webserver")
+			}
+		})
+
+		// Start our webserver.
+		log.WithFields(log.Fields{
+			"address": server.Addr,
+			"tls":     viper.GetString("web.tls.cert") != "",
+		}).Debug("starting webserver")
+		go func() {
+			if err := ListenAndServe(server); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				Fatal(ExitInternalError, log.WithFields(log.Fields{
+					"err": err,
+				}), "webserver failed")
+			}
+		}()
+	} else {
+		log.Info("webserver disabled, waiting for shutdown signal")
+	}
+
+	// Wait until we're asked to shut down.
+	<-ctx.Done()
+	log.Info("shutting down")
+	Cleanup()
+}
+
+// RegistryName identifies the registry among those of a run, by its region and
+// the AWS profile it's reconciled through if any.
+func RegistryName(registry Registry) string {
+	if registry.Profile == "" {
+		return registry.Region
+	}
+	return registry.Profile + "/" + registry.Region
+}
+
+// NewRegistry creates the registry of the AWS configuration's region, along with
+// the notifiers its scan requests are delivered to.
+func NewRegistry(cfg aws.Config, metrics *Metrics, state StateStore, limiter *rate.Limiter) Registry {
+	log.WithFields(log.Fields{
+		"region": cfg.Region,
+	}).Debug("creating AWS ECR client")
+	registry := Registry{
+		Client: RetryingClient{
+			ECRAPI: ecr.NewFromConfig(cfg, func(o *ecr.Options) {
+				o.APIOptions = append(o.APIOptions, RecordRequestDuration(metrics))
+			}),
+			Metrics:     metrics,
+			Region:      cfg.Region,
+			MaxAttempts: viper.GetInt("scan.retry.max_attempts"),
+			BaseDelay:   viper.GetDuration("scan.retry.base_delay"),
+		},
+		Limiter: limiter,
+		Logger:  log.NewEntry(log.StandardLogger()),
+		Metrics: metrics,
+		Region:  cfg.Region,
+		State:   state,
+	}
+	if id := viper.GetString("aws.registry_id"); id != "" {
+		registry.ID = aws.String(id)
+	}
+
+	// Setup our notifications if we have anywhere to deliver them to.
+	if topic := viper.GetString("notifications.sns.topic_arn"); topic != "" {
+		notifier, err := NewSNSNotifier(cfg, metrics, topic)
 		if err != nil {
 			log.WithFields(log.Fields{
+				"err":   err,
+				"topic": topic,
+			}).Error("invalid AWS SNS topic ARN, notifications disabled")
+		} else {
+			registry.Notifiers = append(registry.Notifiers, notifier)
+		}
+	}
+	if viper.GetBool("notifications.eventbridge.enabled") {
+		registry.Notifiers = append(registry.Notifiers, NewEventBridgeNotifier(
+			cfg,
+			metrics,
+			viper.GetString("notifications.eventbridge.bus_name"),
+		))
+	}
+	if auditLog != nil {
+		registry.Notifiers = append(registry.Notifiers, auditLog)
+	}
+	if findingsLines != nil {
+		registry.Notifiers = append(registry.Notifiers, findingsLines)
+	}
+	if webhook != nil {
+		registry.Notifiers = append(registry.Notifiers, webhook)
+	}
+	return registry
+}
+
+// NewRateLimiter creates the limiter pacing our scan requests, or nil if they
+// aren't paced.
+func NewRateLimiter() *rate.Limiter {
+	limit := viper.GetFloat64("scan.rate_limit")
+	if limit <= 0 {
+		return nil
+	}
+	burst := viper.GetInt("scan.rate_burst")
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(limit), burst)
+}
+
+// TriggerScans reconciles every configured registry, blocking until all of the
+// resulting scan requests have finished, and delivers the summary of the run.
+func TriggerScans(
+	ctx context.Context,
+	metrics *Metrics,
+	pool *WorkerPool,
+	state StateStore,
+	queue *WorkQueue,
+	deferred *DeferredScans,
+	findings *FindingsCollector,
+	summaries []RunNotifier,
+	health *Health,
+) RunResult {
+	// Summaries are delivered even if the run itself timed out.
+	parent := ctx
+
+	// Tell the logs of this run apart from those of any other, down to every
+	// repository and image.
+	id := NewRunID()
+	logger := log.WithFields(log.Fields{
+		"run": id,
+	})
+
+	// Trace the run as a whole, with every repository and image beneath it.
+	ctx, span := tracer.Start(ctx, "TriggerScans", trace.WithAttributes(
+		attribute.String("run.id", id),
+	))
+	defer span.End()
+
+	// Derive a single context for the whole run, which every AWS call made
+	// throughout the run uses, so that cancelling it or hitting its deadline
+	// stops all of them.
+	var cancel context.CancelFunc
+	if timeout := viper.GetDuration("scan.run_timeout"); timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	// Ensure it's observable that we're in the middle of a run.
+	start := time.Now()
+	metrics.RunInProgress.Set(1)
+	defer metrics.RunInProgress.Set(0)
+
+	// Determine which regions we're reconciling in each profile, falling back to
+	// the region resolved by the default AWS configuration chain.
+	regions := Regions()
+
+	// Accumulate the findings of the whole run if we're reporting them as SARIF.
+	var report *SARIFReport
+	if SARIFEnabled() {
+		report = NewSARIFReport()
+	}
+
+	// Only list the resource tags of each repository once throughout the run.
+	tags := NewTagCache()
+
+	// Likewise only check the digest list files for changes once.
+	digestFiles.Refresh()
+
+	// Pace our scan requests across every registry if asked to.
+	limiter := NewRateLimiter()
+
+	// Describe the findings of images through a pool of their own, paced
+	// separately from our scan requests, which is shared with collecting the
+	// findings of recent scans if we do so.
+	var findingsPool *WorkerPool
+	var findingsLimiter *rate.Limiter
+	if findings != nil {
+		findingsPool, findingsLimiter = findings.Pool, findings.Limiter
+	} else {
+		findingsPool = NewFindingsPool()
+		defer findingsPool.Close()
+		findingsLimiter = NewFindingsLimiter()
+	}
+
+	// Cap the scans requested throughout the run if asked to, resuming from
+	// wherever the next run should start once we're done.
+	budget := NewScanBudget(viper.GetInt64("scan.max_per_run"))
+	defer budget.Remember()
+
+	// Abort the run altogether if a runaway registry has more images than we
+	// could ever reasonably scan.
+	safety := NewSafetyLimit(viper.GetInt64("safety.max_total_images"), cancel)
+
+	// Record the scans of each image in the cluster if asked to.
+	var resources *KubernetesNotifier
+	if viper.GetBool("kubernetes.enabled") {
+		var err error
+		resources, err = NewKubernetesNotifier(metrics)
+		if err != nil {
+			logger.WithFields(log.Fields{
 				"err": err,
-			}).Fatal("failed to retrieve next page of repositories")
+			}).Error("failed to create Kubernetes client, ImageScan resources disabled")
 		}
+	}
+
+	var result RunResult
+registries:
+	for _, profile := range Profiles() {
+		for _, region := range regions {
+			if err := ctx.Err(); err != nil {
+				logger.WithFields(log.Fields{
+					"err": err,
+				}).Warn("run cancelled, skipping remaining regions")
+				break registries
+			}
+
+			// Reconcile our AWS client configuration for the region.
+			logger.WithFields(log.Fields{
+				"profile": profile,
+				"region":  region,
+			}).Debug("loading AWS configuration")
+			// A region we can't even load the configuration of fails like any
+			// other, rather than abandoning the rest of the run.
+			cfg, err := LoadAWSConfig(ctx, AWSConfigOptions(profile, region)...)
+			if err != nil {
+				logger.WithFields(log.Fields{
+					"err":     err,
+					"profile": profile,
+					"region":  region,
+				}).Error("failed to load AWS configuration, skipping region")
+				metrics.WithProfile(profile).RegionErrors.WithLabelValues(region).Inc()
+				if viper.GetString("run.region_failure_policy") != RegionFailurePolicyIgnore {
+					result.Failures++
+				}
+				continue
+			}
 
-		for _, repository := range response.Repositories {
-			go ReconcileRepository(ecrctx, repository)
+			// Create our AWS client object to be passed along to each reconciliation.
+			registry := NewRegistry(cfg, metrics.WithProfile(profile), state, limiter)
+			registry.Profile = profile
+			registry.Budget = budget
+			registry.Deferred = deferred
+			registry.Findings = findings
+			registry.Health = health
+			registry.Logger = logger
+			registry.FindingsLimiter = findingsLimiter
+			registry.FindingsPool = findingsPool
+			registry.Queue = queue
+			registry.Safety = safety
+			registry.Tags = tags
+			if report != nil {
+				registry.Notifiers = append(registry.Notifiers, report)
+			}
+			if resources != nil {
+				registry.Notifiers = append(registry.Notifiers, resources)
+			}
+
+			// A region we can't describe the repositories of doesn't stop us from
+			// moving on to the next, and the run still succeeds as long as any
+			// region was reconciled.
+			var reconciled RunResult
+			if name, _ := TargetedImages(); name != "" {
+				reconciled, err = ReconcileTargetedImages(ctx, registry, pool)
+			} else {
+				reconciled, err = ReconcileRegistry(ctx, registry, pool)
+			}
+			if err == nil {
+				reconciled.Reconciled++
+			} else {
+				if ctx.Err() == nil {
+					registry.Metrics.RegionErrors.WithLabelValues(registry.Region).Inc()
+				}
+				if viper.GetString("run.region_failure_policy") != RegionFailurePolicyIgnore {
+					reconciled.Failures++
+				}
+			}
+			logger.WithFields(reconciled.Fields()).WithFields(log.Fields{
+				"profile": profile,
+				"region":  registry.Region,
+			}).Debug("registry reconciled")
+			result.Merge(reconciled)
+		}
+	}
+
+	// Enumerate the public registry of each profile too if asked to, whose API
+	// is only offered in a single region. It counts as a registry reconciled,
+	// or failed, just like any region.
+	if viper.GetBool("aws.public_registry") && ctx.Err() == nil {
+		if name, _ := TargetedImages(); name == "" {
+			for _, profile := range Profiles() {
+				cfg, err := LoadAWSConfig(ctx, AWSConfigOptions(profile, PublicRegion)...)
+				if err != nil {
+					logger.WithFields(log.Fields{
+						"err":     err,
+						"profile": profile,
+						"region":  PublicRegion,
+					}).Error("failed to load AWS configuration, skipping public registry")
+					metrics.WithProfile(profile).RegionErrors.WithLabelValues(PublicRegistryRegion).Inc()
+					if viper.GetString("run.region_failure_policy") != RegionFailurePolicyIgnore {
+						result.Failures++
+					}
+					continue
+				}
+				reconciled, err := ReconcilePublicRegistry(
+					ctx,
+					ecrpublic.NewFromConfig(cfg),
+					metrics.WithProfile(profile),
+					logger.WithFields(log.Fields{
+						"profile": profile,
+					}),
+				)
+				if err == nil {
+					reconciled.Reconciled++
+				} else {
+					if ctx.Err() == nil {
+						metrics.WithProfile(profile).RegionErrors.WithLabelValues(PublicRegistryRegion).Inc()
+					}
+					if viper.GetString("run.region_failure_policy") != RegionFailurePolicyIgnore {
+						reconciled.Failures++
+					}
+				}
+				result.Merge(reconciled)
+			}
+		}
+	}
+
+	// Write out the findings we've collected throughout the run.
+	if report != nil {
+		if err := WriteSARIF(ctx, report); err != nil {
+			logger.WithFields(log.Fields{
+				"err": err,
+			}).Error("failed to write SARIF findings report")
+			result.Failures++
 		}
 	}
+
+	// Make it known if we ran out of time, and what we didn't get to.
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		logger.WithFields(log.Fields{
+			"incomplete": result.Incomplete,
+			"timeout":    viper.GetDuration("scan.run_timeout"),
+		}).Warn("run timed out before all repositories completed")
+		metrics.RunTimeouts.Inc()
+	}
+
+	// Likewise if we ran out of budget, the next run picks up where we left off.
+	if result.OverBudget > 0 {
+		logger.WithFields(log.Fields{
+			"budget":  viper.GetInt64("scan.max_per_run"),
+			"skipped": result.OverBudget,
+		}).Warn("run exhausted its scan budget, skipped the remaining images")
+	}
+
+	// A run whose scan requests were mostly rate-limited was mostly wasted,
+	// which usually means it runs too often for the 24 hour quota window.
+	if threshold := viper.GetFloat64("scan.rate_limited_warn_ratio"); threshold > 0 {
+		if ratio := result.RateLimitedRatio(); ratio >= threshold {
+			logger.WithFields(log.Fields{
+				"ratio":     ratio,
+				"threshold": threshold,
+			}).Warn("most scan requests of the run were rate-limited, consider a less frequent schedule")
+			metrics.ScheduleTooFrequent.Set(1)
+		} else {
+			metrics.ScheduleTooFrequent.Set(0)
+		}
+	}
+
+	// Summarize the run as a whole so its health is clear at a glance.
+	duration := time.Since(start)
+	metrics.RunLastDuration.Set(duration.Seconds())
+	if result.Succeeded() {
+		metrics.RunLastSuccess.SetToCurrentTime()
+	}
+	logger.WithFields(result.Fields()).WithFields(log.Fields{
+		"duration": duration,
+	}).Info("run finished")
+	span.SetAttributes(ResultAttributes(result)...)
+	if err := ctx.Err(); err != nil {
+		RecordSpanError(span, err)
+	}
+	for _, summary := range summaries {
+		summary.NotifyRun(parent, result, duration)
+	}
+	return result
 }
 
-func ReconcileRepository(ctx context.Context, repository types.Repository) {
+// RedactedSettings returns all of our configuration with the values of any
+// sensitive elements redacted, so that it's safe to log.
+func RedactedSettings() map[string]interface{} {
+	settings := viper.AllSettings()
+	for _, key := range sensitiveKeys {
+		if viper.GetString(key) == "" && len(viper.GetStringMap(key)) == 0 {
+			continue
+		}
+
+		// Walk down to the map holding the element.
+		parts := strings.Split(key, ".")
+		current := settings
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := current[part].(map[string]interface{})
+			if !ok {
+				current = nil
+				break
+			}
+			current = next
+		}
+		if current != nil {
+			current[parts[len(parts)-1]] = "<redacted>"
+		}
+	}
+	return settings
+}
+
+// ReconcileRegistry reconciles every repository in the given registry, returning
+// the combined result once all of them have finished. An error is returned if
+// the repositories of the registry couldn't be described.
+func ReconcileRegistry(
+	ctx context.Context,
+	registry Registry,
+	pool *WorkerPool,
+) (RunResult, error) {
 	// Setup our logging context for the function.
-	logger := log.WithFields(log.Fields{
+	logger := registry.Logger.WithFields(log.Fields{
+		"region": registry.Region,
+	})
+
+	// Determine the registry scanning configuration once for the whole run so we
+	// know which repositories are already scanned continuously.
+	logger.Debug("retrieving AWS ECR registry scanning configuration")
+	configuration, err := FetchScanningConfiguration(ctx, registry)
+	if err != nil {
+		logger.WithFields(log.Fields{
+			"err": err,
+		}).Warn("failed to retrieve registry scanning configuration, assuming no continuous scanning")
+	}
+
+	// Converge the registry to our desired scanning configuration first if we're
+	// managing it, as it decides which repositories we scan ourselves. Without
+	// the current configuration we can't tell whether it needs converging.
+	var convergeErr error
+	if viper.GetBool("scan.desired_configuration.enabled") && err == nil {
+		configuration, convergeErr = ConvergeScanningConfiguration(ctx, registry, configuration)
+		if convergeErr != nil {
+			logger.WithFields(log.Fields{
+				"err": convergeErr,
+			}).Error("failed to converge registry scanning configuration")
+		}
+	}
+	registry.ContinuousScanFilters = NewContinuousScanFilters(configuration)
+
+	// Determine the repositories to reconcile, either from the explicitly
+	// configured names or by describing every repository in the registry.
+	var repositories []types.Repository
+	var describeErr error
+	if names := viper.GetStringSlice("repositories.names"); len(names) > 0 {
+		logger.Debug("using configured AWS ECR repository names")
+		repositories = RepositoriesFromNames(names)
+	} else {
+		logger.Debug("describing AWS ECR repositories")
+		repositories, describeErr = DescribeRepositories(ctx, registry)
+		if describeErr != nil && ctx.Err() != nil {
+			logger.WithFields(log.Fields{
+				"described": len(repositories),
+				"err":       describeErr,
+			}).Warn("run cancelled while describing repositories, only some were described")
+		} else if describeErr != nil {
+			logger.WithFields(log.Fields{
+				"err": describeErr,
+			}).Warn("failed to describe repositories, skipping region")
+		} else {
+			registry.Health.MarkReady("describe")
+		}
+	}
+
+	// Start from wherever the previous run ran out of budget, if it did.
+	repositories = ResumeRepositories(RegistryName(registry), repositories)
+
+	// Read the repositories to reconcile afresh on each run, as their file may
+	// have changed since the last.
+	includes, ok := RepositoryIncludes()
+	if !ok {
+		repositories = nil
+	}
+
+	// Pass each repository off to be reconciled, only so many at once as each
+	// of them lists its images and describes them all in quick succession.
+	concurrency := viper.GetInt("scan.repository_concurrency")
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	repositoryPool := NewWorkerPool(concurrency)
+	defer repositoryPool.Close()
+	// The repositories being reconciled merge their results separately from
+	// ours, which only this loop updates.
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var result, repositoriesResult RunResult
+	if convergeErr != nil {
+		result.Failures++
+	}
+	for _, repository := range repositories {
+		if !ShouldReconcileRepository(*repository.RepositoryName, includes) {
+			logger.WithFields(log.Fields{
+				"repository": *repository.RepositoryName,
+			}).Debug("repository filtered out, skipping")
+			continue
+		}
+		if viper.GetBool("scan.skip_scan_on_push") && IsScanOnPush(repository) {
+			logger.WithFields(log.Fields{
+				"repository": *repository.RepositoryName,
+			}).Debug("repository scans on push, skipping")
+			registry.Metrics.RepositoriesSkippedScanOnPush.WithLabelValues(registry.Region).Inc()
+			continue
+		}
+		selected, err := IsSelectedByTag(ctx, registry, repository)
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"err":        err,
+				"repository": *repository.RepositoryName,
+			}).Error("failed to list repository tags, skipping")
+			result.Failures++
+			continue
+		}
+		if !selected {
+			logger.WithFields(log.Fields{
+				"repository": *repository.RepositoryName,
+				"selector":   viper.GetString("repositories.select_by_tag"),
+			}).Debug("repository doesn't carry the selected tag, skipping")
+			registry.Metrics.RepositoriesSkippedTag.WithLabelValues(registry.Region).Inc()
+			continue
+		}
+
+		// Once the run is cancelled there's no point in starting any more
+		// repositories, but we keep note of them.
+		if ctx.Err() != nil {
+			result.Incomplete = append(result.Incomplete, *repository.RepositoryName)
+			continue
+		}
+
+		result.Repositories++
+		wg.Add(1)
+		repository := repository
+		repositoryPool.Submit(func() {
+			defer wg.Done()
+
+			// Bound each repository by a timeout of its own if given one, so
+			// that a single slow repository can't take up the entire run.
+			repositoryCtx, cancel := ctx, context.CancelFunc(func() {})
+			if timeout := viper.GetDuration("scan.repository_timeout"); timeout > 0 {
+				repositoryCtx, cancel = context.WithTimeout(ctx, timeout)
+			}
+			reconciled := ReconcileRepository(repositoryCtx, registry, pool, repository)
+			timedOut := repositoryCtx.Err() != nil
+			cancel()
+
+			// If the run was cancelled by the time we finished, the repository
+			// was most likely cut short. Otherwise it may have been cut short
+			// by its own timeout, and the run moves on to the others.
+			if ctx.Err() != nil {
+				reconciled.Incomplete = append(reconciled.Incomplete, *repository.RepositoryName)
+			} else if timedOut {
+				logger.WithFields(log.Fields{
+					"repository": *repository.RepositoryName,
+					"timeout":    viper.GetDuration("scan.repository_timeout"),
+				}).Warn("repository timed out, moving on to the rest of the run")
+				registry.Metrics.RepositoryTimeouts.WithLabelValues(registry.Region, RepositoryLabel(repository)).Inc()
+			}
+
+			mutex.Lock()
+			repositoriesResult.Merge(reconciled)
+			mutex.Unlock()
+		})
+	}
+
+	// Wait for all of the repositories to finish reconciling.
+	wg.Wait()
+	result.Merge(repositoriesResult)
+
+	// Only a run that got through the entire registry knows how large it is.
+	if describeErr == nil && ctx.Err() == nil {
+		registry.Metrics.RepositoriesDiscovered.WithLabelValues(registry.Region, "enumerated").Set(float64(len(repositories)))
+		registry.Metrics.RepositoriesDiscovered.WithLabelValues(registry.Region, "selected").Set(float64(result.Repositories))
+		registry.Metrics.ImagesDiscovered.WithLabelValues(registry.Region, "enumerated").Set(float64(result.Images))
+		registry.Metrics.ImagesDiscovered.WithLabelValues(registry.Region, "selected").Set(float64(result.Images - result.Skipped))
+	}
+	return result, describeErr
+}
+
+// ReconcileRepository requests scans of every image in the given repository,
+// returning the result once all of them have finished.
+func ReconcileRepository(
+	ctx context.Context,
+	registry Registry,
+	pool *WorkerPool,
+	repository types.Repository,
+) RunResult {
+	// Setup our logging context for the function.
+	logger := registry.Logger.WithFields(log.Fields{
+		"region":     registry.Region,
 		"repository": *repository.RepositoryName,
 	})
 	logger.Info("reconciling respository")
+	ctx, span := tracer.Start(ctx, "ReconcileRepository", trace.WithAttributes(
+		attribute.String("region", registry.Region),
+		attribute.String("repository", *repository.RepositoryName),
+	))
+	defer span.End()
 
-	// Retrieve the AWS ECR client from the provided context.
-	client := ctx.Value(AwsEcrClientKey{}).(*ecr.Client)
+	// Resolve the settings of this repository, which may be overridden.
+	config := ResolveRepositoryConfig(*repository.RepositoryName)
 
 	// Determine the image filter to use.
 	status := types.TagStatusAny
@@ -172,46 +1605,386 @@ func ReconcileRepository(ctx context.Context, repository types.Repository) {
 	}
 
 	// Create a paginator for listing images in case we have a lot.
-	paginator := ecr.NewListImagesPaginator(client, &ecr.ListImagesInput{
+	paginator := ecr.NewListImagesPaginator(registry.Client, &ecr.ListImagesInput{
 		Filter:         &types.ListImagesFilter{TagStatus: status},
+		RegistryId:     registry.ID,
 		RepositoryName: repository.RepositoryName,
 	})
 
-	// While we still have pages, grab the next one and send off those images to
+	// While we still have pages, grab the next one and gather up the images to
 	// initiate scans against.
+	var images []types.ImageIdentifier
+	var found int64
+
+	// Whatever each step below leaves out is accounted for as skipped as we go,
+	// while the platform-specific images that expanding manifest lists reaches
+	// count as found too.
+	var added, skipped int64
+	account := func(before int, after int) {
+		if change := int64(after - before); change > 0 {
+			added += change
+		} else {
+			skipped -= change
+		}
+	}
 	for paginator.HasMorePages() {
+		// Stop paging once the run is cancelled, our caller notes the
+		// repository as incomplete.
+		if err := ctx.Err(); err != nil {
+			logger.WithFields(log.Fields{
+				"err":    err,
+				"images": found,
+			}).Warn("run cancelled while listing images, skipping the rest of the repository")
+			return RunResult{Images: found, Skipped: skipped}
+		}
 		response, err := paginator.NextPage(ctx)
 		if err != nil {
-			logger.Fatal(err)
+			// The repository may well have been deleted since we described it,
+			// in which case there's simply nothing left to scan.
+			var rnfe *types.RepositoryNotFoundException
+			if errors.As(err, &rnfe) {
+				logger.WithFields(log.Fields{
+					"err": err,
+				}).Warn("repository no longer exists, skipping")
+				registry.Metrics.RepositoriesNotFound.WithLabelValues(registry.Region).Inc()
+				return RunResult{Images: found, Skipped: skipped}
+			}
+			// Anything else abandons just this repository, leaving the rest of
+			// the run to proceed.
+			logger.WithFields(log.Fields{
+				"err": err,
+			}).Error("failed to retrieve next page of images, skipping the rest of the repository")
+			registry.Metrics.ListImagesErrors.WithLabelValues(registry.Region, RepositoryLabel(repository)).Inc()
+			return RunResult{Failures: 1, Images: found, Skipped: skipped}
+		}
+		found += int64(len(response.ImageIds))
+		if !registry.Safety.Add(registry, len(response.ImageIds)) {
+			return RunResult{Failures: 1, Images: found, Skipped: skipped}
 		}
 
-		// Start the process to request an image scan against each image.
 		for _, image := range response.ImageIds {
-			go ReconcileImage(ctx, repository, image)
+			if !ShouldReconcileImage(image, config) {
+				logger.WithFields(log.Fields{
+					"image": map[string]string{
+						"digest": *image.ImageDigest,
+						"tag":    ImageTag(image),
+					},
+				}).Debug("image filtered out, skipping")
+				skipped++
+				continue
+			}
+			images = append(images, image)
+		}
+	}
+
+	// Make it known we saw the repository even though there's nothing in it,
+	// which may well be a sign of a misconfigured repository.
+	if found == 0 {
+		logger.Info("repository has no images")
+		registry.Metrics.RepositoriesEmpty.WithLabelValues(registry.Region).Inc()
+		return RunResult{}
+	}
+
+	// The images behind a mutable repository's tags come and go as the tags are
+	// overwritten, leaving the images no longer tagged as anything in
+	// particular, so we may only care about whatever's currently the latest.
+	// This has to happen before deduplicating, which keeps just one of the tags.
+	if viper.GetBool("images.only_latest_on_mutable") && IsTagMutable(repository) {
+		tag := viper.GetString("images.latest_tag")
+		logger.WithFields(log.Fields{
+			"tag": tag,
+		}).Debug("repository is mutable, only scanning the latest image")
+		before := len(images)
+		images = SelectTaggedImages(images, tag)
+		account(before, len(images))
+	}
+
+	// Repositories of released versions may only need the latest patch of
+	// each series scanned. This also has to happen before deduplicating, as it
+	// goes by every tag of each image.
+	if viper.GetBool("images.semver_latest") {
+		before := len(images)
+		images = SelectLatestSemver(registry, repository, images, viper.GetString("images.semver_latest_other"))
+		account(before, len(images))
+	}
+
+	// AWS ECR can't scan a multi-arch manifest list itself, only the
+	// platform-specific images it refers to, so scan those instead.
+	if viper.GetBool("images.expand_manifest_lists") && len(images) > 0 {
+		lists, err := FetchManifestLists(ctx, registry, repository, images)
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"err": err,
+			}).Warn("failed to retrieve image manifests, continuing without expanding manifest lists")
+		} else {
+			platforms, _ := SelectedPlatforms()
+			before := len(images)
+			images = ExpandManifestLists(registry, repository, images, lists, platforms)
+			account(before, len(images))
+		}
+	}
+
+	// Scans apply to an image's digest, so only request one per digest however
+	// many tags it has.
+	before := len(images)
+	images = DeduplicateImages(registry, repository, images)
+	account(before, len(images))
+
+	// Describe the images if any of the selection steps below need details that
+	// ListImages doesn't provide.
+	limit := config.MaxPerRepository
+	interval := config.MinInterval
+	age := viper.GetDuration("images.max_age")
+	// Our state store spares us describing images just to skip recent scans.
+	recent := interval > 0 && registry.State == nil
+	include, _ := ParseScanStatuses(viper.GetStringSlice("scan.status_filter.include"))
+	exclude, _ := ParseScanStatuses(viper.GetStringSlice("scan.status_filter.exclude"))
+	statuses := len(include) > 0 || len(exclude) > 0
+	order := viper.GetString("scan.order")
+	pushed := order == ScanOrderNewest || order == ScanOrderOldest
+	incremental := viper.GetBool("scan.incremental") && registry.State != nil
+	var details map[string]types.ImageDetail
+	if (limit > 0 || recent || age > 0 || statuses || pushed || incremental) && len(images) > 0 {
+		var err error
+		details, err = FetchImageDetails(ctx, registry, repository, images)
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"err": err,
+			}).Warn("failed to describe images, continuing without image details")
 		}
 	}
+
+	// Leave out images pushed too long ago, before capping the amount of images
+	// so that the cap only applies to the images we would scan.
+	if age > 0 && details != nil {
+		before := len(images)
+		images = SkipOldImages(registry, repository, images, details, age, time.Now())
+		account(before, len(images))
+	}
+
+	// In incremental mode only the images pushed since the previous run are
+	// reconciled, as told by the repository's watermark.
+	var watermark time.Time
+	if incremental && details != nil {
+		before := len(images)
+		images, watermark = SkipBeforeWatermark(ctx, registry, repository, images, details)
+		account(before, len(images))
+	}
+
+	// Likewise only keep the images whose previous scan status we're after, such
+	// as failed scans to retry. Skipping recent scans further down still applies
+	// to whatever the status filter keeps.
+	if statuses && details != nil {
+		before := len(images)
+		images = FilterScanStatus(registry, repository, images, details, include, exclude)
+		account(before, len(images))
+	}
+
+	// Only keep the most recently pushed images if we're capping the amount of
+	// images per repository.
+	if limit > 0 && details != nil {
+		var older int
+		images, older = SelectNewestImages(images, details, limit)
+		if older > 0 {
+			logger.WithFields(log.Fields{
+				"limit":   limit,
+				"skipped": older,
+			}).Debug("skipping older images beyond the maximum per repository")
+			registry.Metrics.ImagesSkippedMaxPerRepository.WithLabelValues(registry.Region).Add(float64(older))
+			skipped += int64(older)
+		}
+	}
+
+	// Read the findings of the previous scans before we request new ones, which
+	// we also need to do if we're gating on a severity threshold or reporting
+	// the findings elsewhere.
+	result := RunResult{Images: found + added}
+	threshold, _ := ParseSeverity(viper.GetString("scan.fail_threshold"))
+	collect := viper.GetBool("findings.enabled") ||
+		threshold != "" ||
+		SARIFEnabled() ||
+		JSONLEnabled() ||
+		viper.GetBool("kubernetes.enabled")
+	if collect {
+		result.OverThreshold = CollectFindings(ctx, registry, repository, images, threshold)
+	}
+	collected := images
+
+	// Repositories covered by continuous scanning are scanned by AWS ECR itself,
+	// so there's no point in requesting scans against them.
+	if registry.ContinuousScanFilters.Covers(*repository.RepositoryName) {
+		logger.Info("repository is continuously scanned, skipping image scans")
+		registry.Metrics.ScansSkippedContinuous.WithLabelValues(registry.Region).Add(float64(len(images)))
+		result.Skipped = result.Images
+		return result
+	}
+
+	// Skip any images that were scanned recently enough that AWS ECR would just
+	// reject another scan request.
+	before = len(images)
+	if interval > 0 && registry.State != nil {
+		images = SkipRecentlyScannedState(ctx, registry, repository, images, interval)
+	} else if interval > 0 && details != nil {
+		images = SkipRecentlyScanned(registry, repository, images, details, interval)
+	}
+	account(before, len(images))
+
+	// Whatever was left out along the way, whether filtered, deduplicated or
+	// scanned recently, is accounted for as skipped.
+	result.Skipped = skipped
+
+	// Request the scans in the configured order, which decides the images that
+	// get scanned when the run is cut short by its budget or a timeout. Without
+	// the push times of the images they're left in the order they're listed.
+	if !pushed || details != nil {
+		images = OrderImages(images, details, order)
+	}
+
+	// Bound how many of the repository's images are scanned at once if its
+	// overrides ask us to, within the bounds of the worker pool itself.
+	var slots chan struct{}
+	if config.Concurrency > 0 {
+		slots = make(chan struct{}, config.Concurrency)
+	}
+
+	// Enqueue each image onto the worker pool to request an image scan, and
+	// wait for all of them to finish.
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for _, image := range images {
+		image := image
+		wg.Add(1)
+		if slots != nil {
+			slots <- struct{}{}
+		}
+		pool.Submit(func() {
+			defer wg.Done()
+			if slots != nil {
+				defer func() { <-slots }()
+			}
+			reconciled := ReconcileImage(ctx, registry, repository, image)
+			mutex.Lock()
+			result.Merge(reconciled)
+			mutex.Unlock()
+		})
+	}
+	wg.Wait()
+
+	// The findings of the scans we've just requested only appear some minutes
+	// later, so keep collecting them in the meantime if asked to.
+	if result.Requested > 0 {
+		registry.Findings.Remember(registry, repository, collected)
+	}
+
+	// Only move the watermark on once every image pushed before it was dealt
+	// with, so that nothing is left behind for good.
+	complete := result.Failures == 0 && result.OverBudget == 0 && result.DryRun == 0 && ctx.Err() == nil
+	if !watermark.IsZero() && complete {
+		RecordWatermark(ctx, registry, repository, watermark)
+	}
+	return result
 }
 
+// ReconcileImage requests a scan of the given image, returning the outcome.
+// Rate-limited requests are not considered a failure.
 func ReconcileImage(
 	ctx context.Context,
+	registry Registry,
 	repository types.Repository,
 	image types.ImageIdentifier,
-) {
-	// Retrieve the AWS ECR client from the provided context.
-	client := ctx.Value(AwsEcrClientKey{}).(*ecr.Client)
-
+) RunResult {
 	// Setup our logging context for the function.
-	logger := log.WithFields(log.Fields{
+	logger := registry.Logger.WithFields(log.Fields{
 		"image": map[string]string{
 			"digest": *image.ImageDigest,
-			"tag":    *image.ImageTag,
+			"tag":    ImageTag(image),
 		},
+		"region":     registry.Region,
 		"repository": *repository.RepositoryName,
 	})
-	logger.Info("requesting image scan")
+	ctx, span := tracer.Start(ctx, "ReconcileImage", trace.WithAttributes(
+		attribute.String("region", registry.Region),
+		attribute.String("repository", *repository.RepositoryName),
+		attribute.String("image.digest", *image.ImageDigest),
+		attribute.String("image.tag", ImageTag(image)),
+	))
+	defer span.End()
+
+	// Don't bother requesting the scan if the run has been cancelled meanwhile.
+	if err := ctx.Err(); err != nil {
+		RecordSpanError(span, err)
+		logger.WithFields(log.Fields{
+			"err": err,
+		}).Debug("run cancelled, skipping image scan")
+		return RunResult{Failures: 1}
+	}
+
+	// Some images are known to be unscannable or are excluded on purpose, which
+	// is checked last thing so that it applies to every image we'd scan,
+	// whether listed, targeted, deferred or taken off the work queue.
+	if !ShouldScanDigest(*image.ImageDigest) {
+		registry.Metrics.ImagesDenylisted.WithLabelValues(registry.Region).Inc()
+		logger.Debug("image digest denylisted or not allowlisted, skipping image scan")
+		return RunResult{Skipped: 1}
+	}
+
+	// Once the run has spent its budget the remaining images wait for the next
+	// run, which starts with them.
+	if !registry.Budget.Take(registry, repository) {
+		logger.Debug("run exhausted its scan budget, skipping image scan")
+		return RunResult{OverBudget: 1}
+	}
+
+	// In dry-run mode we only make it known what we would have scanned.
+	if viper.GetBool("scan.dry_run") {
+		registry.Metrics.ScansDryRun.WithLabelValues(
+			registry.Region,
+			RepositoryLabel(repository),
+		).Inc()
+		logger.WithFields(log.Fields{
+			"repositoryUri": aws.ToString(repository.RepositoryUri),
+		}).Info("dry-run, skipping image scan request")
+		return RunResult{DryRun: 1}
+	}
 
-	_, err := client.StartImageScan(ctx, &ecr.StartImageScanInput{
+	// As a producer we leave requesting the scan to whichever worker picks up
+	// the task, which also paces the requests.
+	if registry.Queue != nil {
+		if err := registry.Queue.Enqueue(ctx, NewScanTask(registry, repository, image)); err != nil {
+			RecordSpanError(span, err)
+			registry.Metrics.WorkQueueErrors.WithLabelValues("send").Inc()
+			logger.WithFields(log.Fields{
+				"err": err,
+			}).Error("failed to enqueue scan task")
+			return RunResult{Failures: 1}
+		}
+		registry.Metrics.WorkTasksEnqueued.WithLabelValues(registry.Region).Inc()
+		logger.Debug("scan task enqueued")
+		return RunResult{Enqueued: 1}
+	}
+
+	// Wait for our turn if we're pacing our requests, which may take until the
+	// run is cancelled.
+	if registry.Limiter != nil {
+		if err := registry.Limiter.Wait(ctx); err != nil {
+			RecordSpanError(span, err)
+			logger.WithFields(log.Fields{
+				"err": err,
+			}).Debug("run cancelled while waiting to request image scan")
+			return RunResult{Failures: 1}
+		}
+	}
+
+	// Decide once whether this image's routine logs are emitted, so that the
+	// logs of a sampled image are complete. Errors are always logged.
+	verbose := LogImage()
+	if verbose {
+		logger.Info("requesting image scan")
+	}
+
+	_, err := registry.Client.StartImageScan(ctx, &ecr.StartImageScanInput{
 		ImageId:        &image,
+		RegistryId:     registry.ID,
 		RepositoryName: repository.RepositoryName,
 	})
 	if err != nil {
@@ -220,23 +1993,124 @@ func ReconcileImage(
 		// twenty-four hours in AWS ECR for an image.
 		var lee *types.LimitExceededException
 		if errors.As(err, &lee) {
-			logger.Info("rate-limiting error detected, skipping image for now")
-			scansRateLimited.Inc()
-			return
+			registry.Metrics.ScansRateLimited.WithLabelValues(
+				registry.Region,
+				RepositoryLabel(repository),
+			).Inc()
+
+			// The image may be about to leave its quota window, in which case
+			// we'd rather retry it shortly than wait for the next run.
+			if registry.Deferred != nil && registry.Deferred.Defer(ctx, registry, repository, image) {
+				return RunResult{RateLimited: 1}
+			}
+			if verbose {
+				logger.Info("rate-limiting error detected, skipping image for now")
+			}
+			return RunResult{RateLimited: 1}
+		}
+
+		// Likewise the repository or image may have been deleted since we
+		// listed it, which is no reason to fail the run.
+		var rnfe *types.RepositoryNotFoundException
+		if errors.As(err, &rnfe) {
+			logger.WithFields(log.Fields{
+				"err": err,
+			}).Warn("repository no longer exists, skipping image")
+			registry.Metrics.RepositoriesNotFound.WithLabelValues(registry.Region).Inc()
+			return RunResult{}
+		}
+		var infe *types.ImageNotFoundException
+		if errors.As(err, &infe) {
+			logger.WithFields(log.Fields{
+				"err": err,
+			}).Warn("image no longer exists, skipping")
+			registry.Metrics.ImagesNotFound.WithLabelValues(registry.Region).Inc()
+			return RunResult{}
 		}
 
-		// Otherwise, ensure the error is observable.
-		scanRequestErrors.Inc()
-		logger.Fatal(err)
+		// Nor is an image AWS ECR can't scan in the first place, which would
+		// otherwise fail every run of registries holding other artifacts.
+		if IsUnsupportedImage(err) {
+			registry.Metrics.ScansUnsupported.WithLabelValues(
+				registry.Region,
+				RepositoryLabel(repository),
+			).Inc()
+			entry := logger.WithFields(log.Fields{
+				"err":           err,
+				"repositoryUri": aws.ToString(repository.RepositoryUri),
+			})
+			if WarnUnsupported(StateKey(registry, repository, image)) {
+				entry.Warn("image not supported by AWS ECR scanning, skipping")
+			} else {
+				entry.Debug("image not supported by AWS ECR scanning, skipping")
+			}
+			return RunResult{Unsupported: 1}
+		}
+
+		// Otherwise, ensure the error is observable and move on so that a
+		// single failing image doesn't take down the rest of the run.
+		RecordSpanError(span, err)
+		registry.Metrics.ScanRequestErrors.WithLabelValues(
+			registry.Region,
+			RepositoryLabel(repository),
+		).Inc()
+		logger.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to request image scan")
+		return RunResult{Failures: 1}
 	}
 
-	// Ensure our scan request success is observable.
-	scansRequested.Inc()
-	logger.WithFields(log.Fields{
-		"image": map[string]string{
-			"digest": *image.ImageDigest,
-			"tag":    *image.ImageTag,
-		},
-		"repository": *repository.RepositoryName,
-	}).Info("scan successfully requested")
+	// Ensure our scan request success is observable, and remembered.
+	registry.Metrics.ScansRequested.WithLabelValues(
+		registry.Region,
+		RepositoryLabel(repository),
+	).Inc()
+	if registry.State != nil {
+		if err := registry.State.RecordScan(ctx, StateKey(registry, repository, image), time.Now()); err != nil {
+			registry.Metrics.StateErrors.WithLabelValues("write").Inc()
+			logger.WithFields(log.Fields{
+				"err": err,
+			}).Warn("failed to record image scan state")
+		}
+	}
+	if verbose {
+		logger.Info("scan successfully requested")
+	}
+	Notify(ctx, registry.Notifiers, NewScanNotification(
+		EventScanRequested,
+		registry,
+		repository,
+		image,
+		nil,
+	))
+	return RunResult{Requested: 1}
+}
+
+// LogImage decides whether the routine logs of an image scan request should be
+// emitted, as per-image logs of large registries can be overwhelming.
+func LogImage() bool {
+	if !viper.GetBool("log.per_image") {
+		return false
+	}
+	rate := viper.GetFloat64("log.sample_rate")
+	return rate >= 1 || rand.Float64() < rate
+}
+
+// ImageTag returns the tag of the given image, or a placeholder if the image is
+// untagged and only referenced by its digest.
+func ImageTag(image types.ImageIdentifier) string {
+	if image.ImageTag == nil {
+		return "<untagged>"
+	}
+	return *image.ImageTag
+}
+
+// RepositoryLabel returns the value of the repository label of the metrics for
+// the given repository. To bound the cardinality of the metrics on registries
+// with many repositories, the label may be disabled in which case it's empty.
+func RepositoryLabel(repository types.Repository) string {
+	if !viper.GetBool("metrics.repository_label") {
+		return ""
+	}
+	return *repository.RepositoryName
 }
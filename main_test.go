@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// update rewrites the golden files under testdata with what the tests produce.
+var update = flag.Bool("update", false, "update the golden files under testdata")
+
+func TestMain(m *testing.M) {
+	SetDefaults()
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// configure overrides the configuration for the duration of the test.
+func configure(t *testing.T, settings map[string]interface{}) {
+	t.Helper()
+	for key, value := range settings {
+		previous := viper.Get(key)
+		viper.Set(key, value)
+		key := key
+		t.Cleanup(func() { viper.Set(key, previous) })
+	}
+}
+
+// testRegistry returns a registry reconciled through the client, with metrics
+// of its own.
+func testRegistry(client ECRAPI) Registry {
+	return Registry{
+		Client:  client,
+		Logger:  log.NewEntry(log.StandardLogger()),
+		Metrics: NewMetrics(prometheus.NewRegistry()),
+		Region:  "us-east-1",
+	}
+}
+
+// golden compares the output with the golden file of the given name under
+// testdata, or rewrites the file with it when updating.
+func golden(t *testing.T, name string, output []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, output, 0o644); err != nil {
+			t.Fatalf("failed to update golden file: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(output, want) {
+		t.Errorf("output differs from %s, rerun with -update if intended:\n%s", path, output)
+	}
+}
+
+func TestImageTag(t *testing.T) {
+	tests := []struct {
+		name  string
+		image types.ImageIdentifier
+		want  string
+	}{
+		{name: "tagged", image: types.ImageIdentifier{ImageDigest: aws.String("sha256:abc"), ImageTag: aws.String("v1")}, want: "v1"},
+		{name: "untagged", image: types.ImageIdentifier{ImageDigest: aws.String("sha256:abc")}, want: "<untagged>"},
+		{name: "empty", image: types.ImageIdentifier{}, want: "<untagged>"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			if got := ImageTag(test.image); got != test.want {
+				t.Errorf("ImageTag() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
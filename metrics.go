@@ -0,0 +1,463 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/spf13/viper"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// metricNamePrefixPattern matches a valid namespace or subsystem of a metric
+// name.
+var metricNamePrefixPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Metrics are the metrics we export, all registered with a single registry so
+// that they can be created afresh wherever needed.
+type Metrics struct {
+	ScansRequested                *prometheus.CounterVec
+	ScanRequestErrors             *prometheus.CounterVec
+	ScansDryRun                   *prometheus.CounterVec
+	ScansRateLimited              *prometheus.CounterVec
+	ScansUnsupported              *prometheus.CounterVec
+	RepositoriesNotFound          *prometheus.CounterVec
+	RegionErrors                  *prometheus.CounterVec
+	RepositoriesEmpty             *prometheus.CounterVec
+	RepositoryTimeouts            *prometheus.CounterVec
+	ListImagesErrors              *prometheus.CounterVec
+	ImagesNotFound                *prometheus.CounterVec
+	RunInProgress                 prometheus.Gauge
+	RunLastSuccess                prometheus.Gauge
+	ScheduleTooFrequent           prometheus.Gauge
+	RunLastDuration               prometheus.Gauge
+	RunTimeouts                   prometheus.Counter
+	SafetyAborts                  prometheus.Counter
+	RunOverlapsSkipped            prometheus.Counter
+	TriggersCoalesced             *prometheus.CounterVec
+	ScansSkippedContinuous        *prometheus.CounterVec
+	RepositoriesDiscovered        *prometheus.GaugeVec
+	ImagesDiscovered              *prometheus.GaugeVec
+	AuditWriteErrors              prometheus.Counter
+	FindingsOutputErrors          prometheus.Counter
+	APIRequestDuration            *prometheus.HistogramVec
+	ScansBudgetExceeded           *prometheus.CounterVec
+	BuildInfo                     *prometheus.GaugeVec
+	ScansDeferred                 *prometheus.CounterVec
+	ImageFindings                 *prometheus.GaugeVec
+	ImagesOverThreshold           *prometheus.GaugeVec
+	ImagesSkippedMaxPerRepository *prometheus.CounterVec
+	ImagesSkippedAge              *prometheus.CounterVec
+	ImagesSkippedSemver           *prometheus.CounterVec
+	ImagesDenylisted              *prometheus.CounterVec
+	ImagesSkippedWatermark        *prometheus.CounterVec
+	ImagesDeduplicated            *prometheus.CounterVec
+	ImageScanWriteErrors          *prometheus.CounterVec
+	IsLeader                      prometheus.Gauge
+	ManifestListsExpanded         *prometheus.CounterVec
+	ImagesSkippedPlatform         *prometheus.CounterVec
+	RepositoriesSkippedTag        *prometheus.CounterVec
+	APIRetries                    *prometheus.CounterVec
+	ScansSkippedRecent            *prometheus.CounterVec
+	RepositoriesSkippedScanOnPush *prometheus.CounterVec
+	ImagesSkippedScanStatus       *prometheus.CounterVec
+	ScanningConfigurationUpdates  *prometheus.CounterVec
+	NotificationsSent             *prometheus.CounterVec
+	NotificationsErrors           *prometheus.CounterVec
+	StateErrors                   *prometheus.CounterVec
+	WorkTasksEnqueued             *prometheus.CounterVec
+	WorkTasksRetried              *prometheus.CounterVec
+	WorkQueueErrors               *prometheus.CounterVec
+
+	profiles bool
+}
+
+// NewMetrics creates every metric we export, registering them with the given
+// registry under the configured namespace and subsystem.
+func NewMetrics(registry *prometheus.Registry) *Metrics {
+	factory := promauto.With(registry)
+	namespace := viper.GetString("metrics.namespace")
+	subsystem := viper.GetString("metrics.subsystem")
+	metrics := &Metrics{profiles: len(viper.GetStringSlice("aws.profiles")) > 0}
+
+	// The metrics recorded per registry are labeled by the AWS profile of the
+	// registry too when we're scanning across several.
+	regional := func(labels ...string) []string {
+		if metrics.profiles {
+			return append([]string{"profile"}, labels...)
+		}
+		return labels
+	}
+
+	metrics.ScansRequested = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_scans_requested",
+		Help:      "The total count of AWS ECR image scan requests sent.",
+	}, regional("region", "repository"))
+	metrics.ScanRequestErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_scans_requested_errors",
+		Help:      "The total count of AWS ECR image scan requests that results in an error.",
+	}, regional("region", "repository"))
+	metrics.ScansDryRun = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_scans_dryrun",
+		Help:      "The total count of AWS ECR image scan requests not sent due to dry-run mode.",
+	}, regional("region", "repository"))
+	metrics.ScansRateLimited = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_scans_rate_limited",
+		Help:      "The total count of AWS ECR image scan requests rejected due to rate-limiting.",
+	}, regional("region", "repository"))
+	metrics.ScansUnsupported = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_scans_unsupported",
+		Help:      "The total count of AWS ECR image scan requests rejected as the image can't be scanned.",
+	}, regional("region", "repository"))
+	metrics.RepositoriesNotFound = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_repository_not_found",
+		Help:      "The total count of AWS ECR repositories deleted before their images could be scanned.",
+	}, regional("region"))
+	metrics.RegionErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_region_errors",
+		Help:      "The total count of runs in which a region's AWS ECR repositories couldn't be described.",
+	}, regional("region"))
+	metrics.RepositoriesEmpty = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_repositories_empty",
+		Help:      "The total count of AWS ECR repositories reconciled without any images.",
+	}, regional("region"))
+	metrics.RepositoryTimeouts = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_repository_timeouts",
+		Help:      "The total count of AWS ECR repositories cut short by the repository timeout.",
+	}, regional("region", "repository"))
+	metrics.ListImagesErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_listimages_errors",
+		Help:      "The total count of AWS ECR repositories abandoned as their images couldn't be listed.",
+	}, regional("region", "repository"))
+	metrics.ImagesNotFound = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_image_not_found",
+		Help:      "The total count of AWS ECR images deleted before their scan could be requested.",
+	}, regional("region"))
+	metrics.RunInProgress = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_scan_run_in_progress",
+		Help:      "Whether a run is currently in progress.",
+	})
+	metrics.RunLastSuccess = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_scan_last_success_timestamp_seconds",
+		Help:      "The time the last successful run finished, in seconds since the epoch.",
+	})
+	metrics.ScheduleTooFrequent = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_scan_schedule_too_frequent",
+		Help:      "Whether most of the last run's scan requests were rate-limited, a sign that the schedule is too frequent.",
+	})
+	metrics.RunLastDuration = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_scan_last_run_duration_seconds",
+		Help:      "The duration of the last run, in seconds.",
+	})
+	metrics.RunTimeouts = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_scan_run_timeouts",
+		Help:      "The total count of runs that timed out before all repositories completed.",
+	})
+	metrics.SafetyAborts = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_safety_abort",
+		Help:      "The total count of runs aborted as they enumerated more images than the safety limit.",
+	})
+	metrics.RunOverlapsSkipped = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_scan_run_overlaps_skipped",
+		Help:      "The total count of scheduled runs skipped as a run was already queued behind the one in progress.",
+	})
+	metrics.TriggersCoalesced = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_scan_triggers_coalesced",
+		Help:      "The total count of run requests coalesced into the run already queued, by source.",
+	}, []string{"source"})
+	metrics.ScansSkippedContinuous = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_scans_skipped_continuous",
+		Help:      "The total count of AWS ECR image scan requests skipped due to continuous scanning.",
+	}, regional("region"))
+	metrics.RepositoriesDiscovered = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_repositories_discovered",
+		Help:      "The count of AWS ECR repositories enumerated by the last complete run, and of those selected by the filters.",
+	}, regional("region", "stage"))
+	metrics.ImagesDiscovered = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_images_discovered",
+		Help:      "The count of AWS ECR images enumerated by the last complete run, and of those selected by the filters.",
+	}, regional("region", "stage"))
+	metrics.AuditWriteErrors = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_audit_write_errors",
+		Help:      "The total count of audit records that failed to be written.",
+	})
+	metrics.FindingsOutputErrors = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_findings_output_errors",
+		Help:      "The total count of images whose findings failed to be written as JSON Lines.",
+	})
+	metrics.APIRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_api_request_duration_seconds",
+		Help:      "The duration of AWS ECR API requests, including the AWS SDK's own retries.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"operation"})
+	metrics.ScansBudgetExceeded = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_scans_budget_exceeded",
+		Help:      "The total count of AWS ECR image scan requests skipped as the run exhausted its budget.",
+	}, regional("region"))
+	metrics.BuildInfo = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_scan_operator_build_info",
+		Help:      "A metric with a constant '1' value labeled by the version, commit and Go version the operator was built from.",
+	}, []string{"version", "commit", "goversion"})
+	metrics.ScansDeferred = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_scans_deferred",
+		Help:      "The total count of rate-limited AWS ECR image scan requests deferred to be retried.",
+	}, regional("region"))
+	metrics.ImageFindings = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_image_findings",
+		Help:      "The count of findings from the most recent AWS ECR image scans in a repository by severity.",
+	}, regional("region", "repository", "severity"))
+	metrics.ImagesOverThreshold = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_images_over_threshold",
+		Help:      "The count of AWS ECR images in a repository with findings at or above the fail threshold.",
+	}, regional("region", "repository"))
+	metrics.ImagesSkippedMaxPerRepository = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_images_skipped_max_per_repository",
+		Help:      "The total count of AWS ECR images skipped due to the maximum images per repository.",
+	}, regional("region"))
+	metrics.ImagesSkippedAge = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_images_skipped_age",
+		Help:      "The total count of AWS ECR images skipped as they were pushed before the maximum age.",
+	}, regional("region"))
+	metrics.ImagesSkippedSemver = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_images_skipped_semver",
+		Help:      "The total count of AWS ECR image identifiers skipped as they aren't the latest of their semantic version series.",
+	}, regional("region"))
+	metrics.ImagesDenylisted = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_images_denylisted",
+		Help:      "The total count of AWS ECR images skipped as their digest is denylisted or not allowlisted.",
+	}, regional("region"))
+	metrics.ImagesSkippedWatermark = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_images_skipped_watermark",
+		Help:      "The total count of AWS ECR images skipped as they were pushed before their repository's watermark.",
+	}, regional("region"))
+	metrics.ImagesDeduplicated = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_images_deduplicated",
+		Help:      "The total count of AWS ECR image identifiers left out as they share a digest with another tag.",
+	}, regional("region"))
+	metrics.ImageScanWriteErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_imagescan_write_errors",
+		Help:      "The total count of ImageScan resources that failed to be written to Kubernetes.",
+	}, []string{"region"})
+	metrics.IsLeader = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_scan_is_leader",
+		Help:      "Whether this replica is the elected leader and runs the scans.",
+	})
+	metrics.ManifestListsExpanded = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_manifest_lists_expanded",
+		Help:      "The total count of AWS ECR multi-arch manifest lists scanned by way of their platform-specific images.",
+	}, regional("region"))
+	metrics.ImagesSkippedPlatform = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_images_skipped_platform",
+		Help:      "The total count of platform-specific AWS ECR images skipped as their platform isn't selected.",
+	}, regional("region"))
+	metrics.RepositoriesSkippedTag = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_repositories_skipped_tag",
+		Help:      "The total count of AWS ECR repositories skipped as they don't carry the selected resource tag.",
+	}, regional("region"))
+	metrics.APIRetries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_api_retries",
+		Help:      "The total count of AWS ECR API requests retried due to a transient error.",
+	}, regional("region", "operation"))
+	metrics.ScansSkippedRecent = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_scans_skipped_recent",
+		Help:      "The total count of AWS ECR image scan requests skipped as the image was scanned recently.",
+	}, regional("region"))
+	metrics.RepositoriesSkippedScanOnPush = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_repositories_skipped_scan_on_push",
+		Help:      "The total count of AWS ECR repositories skipped as they scan images on push.",
+	}, regional("region"))
+	metrics.ImagesSkippedScanStatus = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_images_skipped_scan_status",
+		Help:      "The total count of AWS ECR images skipped due to the status of their previous scan.",
+	}, regional("region"))
+	metrics.ScanningConfigurationUpdates = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_registry_scanning_configuration_updates",
+		Help:      "The total count of AWS ECR registry scanning configuration updates made to converge it.",
+	}, regional("region"))
+	metrics.NotificationsSent = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_notifications_sent",
+		Help:      "The total count of notifications delivered, by channel.",
+	}, []string{"channel"})
+	metrics.NotificationsErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_notifications_errors",
+		Help:      "The total count of notifications that failed to be delivered, by channel.",
+	}, []string{"channel"})
+	metrics.StateErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_state_errors",
+		Help:      "The total count of errors reading or writing the scan state store.",
+	}, []string{"operation"})
+	metrics.WorkTasksEnqueued = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_work_tasks_enqueued",
+		Help:      "The total count of scan tasks enqueued onto the work queue.",
+	}, regional("region"))
+	metrics.WorkTasksRetried = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_work_tasks_retried",
+		Help:      "The total count of scan tasks left on the work queue to be retried.",
+	}, regional("region"))
+	metrics.WorkQueueErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "aws_ecr_work_queue_errors",
+		Help:      "The total count of errors interacting with the work queue.",
+	}, []string{"operation"})
+	return metrics
+}
+
+// RecordDelivery counts a notification delivered through the given channel, or
+// its failure to be if given an error.
+func (m *Metrics) RecordDelivery(channel string, err error) {
+	if err != nil {
+		m.NotificationsErrors.WithLabelValues(channel).Inc()
+		return
+	}
+	m.NotificationsSent.WithLabelValues(channel).Inc()
+}
+
+// WithProfile returns the metrics of the registries of the given AWS profile,
+// which share every metric with the others but label those recorded per
+// registry with the profile. Without a profile, or without any profiles
+// configured to label them with, the metrics are returned as-is.
+func (m *Metrics) WithProfile(profile string) *Metrics {
+	if profile == "" || !m.profiles {
+		return m
+	}
+	labels := prometheus.Labels{"profile": profile}
+	curried := *m
+	curried.ScansRequested = m.ScansRequested.MustCurryWith(labels)
+	curried.ScanRequestErrors = m.ScanRequestErrors.MustCurryWith(labels)
+	curried.ScansDryRun = m.ScansDryRun.MustCurryWith(labels)
+	curried.ScansRateLimited = m.ScansRateLimited.MustCurryWith(labels)
+	curried.ScansUnsupported = m.ScansUnsupported.MustCurryWith(labels)
+	curried.RepositoriesNotFound = m.RepositoriesNotFound.MustCurryWith(labels)
+	curried.RegionErrors = m.RegionErrors.MustCurryWith(labels)
+	curried.RepositoriesEmpty = m.RepositoriesEmpty.MustCurryWith(labels)
+	curried.RepositoryTimeouts = m.RepositoryTimeouts.MustCurryWith(labels)
+	curried.ListImagesErrors = m.ListImagesErrors.MustCurryWith(labels)
+	curried.ImagesNotFound = m.ImagesNotFound.MustCurryWith(labels)
+	curried.ScansSkippedContinuous = m.ScansSkippedContinuous.MustCurryWith(labels)
+	curried.RepositoriesDiscovered = m.RepositoriesDiscovered.MustCurryWith(labels)
+	curried.ImagesDiscovered = m.ImagesDiscovered.MustCurryWith(labels)
+	curried.ScansBudgetExceeded = m.ScansBudgetExceeded.MustCurryWith(labels)
+	curried.ScansDeferred = m.ScansDeferred.MustCurryWith(labels)
+	curried.ImageFindings = m.ImageFindings.MustCurryWith(labels)
+	curried.ImagesOverThreshold = m.ImagesOverThreshold.MustCurryWith(labels)
+	curried.ImagesSkippedMaxPerRepository = m.ImagesSkippedMaxPerRepository.MustCurryWith(labels)
+	curried.ImagesSkippedAge = m.ImagesSkippedAge.MustCurryWith(labels)
+	curried.ImagesSkippedSemver = m.ImagesSkippedSemver.MustCurryWith(labels)
+	curried.ImagesDenylisted = m.ImagesDenylisted.MustCurryWith(labels)
+	curried.ImagesSkippedWatermark = m.ImagesSkippedWatermark.MustCurryWith(labels)
+	curried.ImagesDeduplicated = m.ImagesDeduplicated.MustCurryWith(labels)
+	curried.ManifestListsExpanded = m.ManifestListsExpanded.MustCurryWith(labels)
+	curried.ImagesSkippedPlatform = m.ImagesSkippedPlatform.MustCurryWith(labels)
+	curried.RepositoriesSkippedTag = m.RepositoriesSkippedTag.MustCurryWith(labels)
+	curried.APIRetries = m.APIRetries.MustCurryWith(labels)
+	curried.ScansSkippedRecent = m.ScansSkippedRecent.MustCurryWith(labels)
+	curried.RepositoriesSkippedScanOnPush = m.RepositoriesSkippedScanOnPush.MustCurryWith(labels)
+	curried.ImagesSkippedScanStatus = m.ImagesSkippedScanStatus.MustCurryWith(labels)
+	curried.ScanningConfigurationUpdates = m.ScanningConfigurationUpdates.MustCurryWith(labels)
+	curried.WorkTasksEnqueued = m.WorkTasksEnqueued.MustCurryWith(labels)
+	curried.WorkTasksRetried = m.WorkTasksRetried.MustCurryWith(labels)
+	return &curried
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// EventScanRequested is the event sent once a scan of an image has been
+	// successfully requested.
+	EventScanRequested = "ScanRequested"
+
+	// EventFindingsCollected is the event sent once the findings of the most
+	// recent scan of an image have been collected.
+	EventFindingsCollected = "FindingsCollected"
+
+	// ChannelSNS is the channel of the notifications published to AWS SNS.
+	ChannelSNS = "sns"
+
+	// ChannelEventBridge is the channel of the events put onto AWS
+	// EventBridge.
+	ChannelEventBridge = "eventbridge"
+
+	// ChannelSlack is the channel of the run summaries posted to Slack.
+	ChannelSlack = "slack"
+
+	// ChannelWebhook is the channel of the payloads posted to a generic HTTP
+	// webhook.
+	ChannelWebhook = "webhook"
+
+	// EventBridgeSource is the source of the events put onto AWS EventBridge.
+	EventBridgeSource = "aws-ecr-scan-operator"
+
+	// EventBridgeDetailType is the detail-type of the events put onto AWS
+	// EventBridge whenever a scan is requested.
+	EventBridgeDetailType = "ECRScanRequested"
+)
+
+// Notifier delivers scan notifications to an external system. Failures are
+// handled by the notifier itself, as notifications must never abort
+// reconciliation.
+type Notifier interface {
+	Notify(ctx context.Context, notification ScanNotification)
+}
+
+// Notify delivers the given notification through each of the notifiers.
+func Notify(ctx context.Context, notifiers []Notifier, notification ScanNotification) {
+	for _, notifier := range notifiers {
+		notifier.Notify(ctx, notification)
+	}
+}
+
+// RunNotifier delivers the summary of a finished run to an external system.
+// Much like a Notifier, failures are handled by the notifier itself.
+type RunNotifier interface {
+	NotifyRun(ctx context.Context, result RunResult, duration time.Duration)
+}
+
+// ScanNotification is the payload published whenever a scan is requested or
+// its findings are collected.
+type ScanNotification struct {
+	Event       string           `json:"event"`
+	Region      string           `json:"region"`
+	Repository  string           `json:"repository"`
+	ImageDigest string           `json:"imageDigest"`
+	ImageTag    string           `json:"imageTag"`
+	Findings    map[string]int32 `json:"findings,omitempty"`
+
+	// RegistryID is the ID of the image's registry, if not the default one, and
+	// also never included in the payload.
+	RegistryID string `json:"-"`
+
+	// Details are the individual findings, which are only collected for the
+	// consumers that need them and never included in the payload.
+	Details []types.ImageScanFinding `json:"-"`
+
+	// ScanCompletedAt is when the scan the findings are from completed, if
+	// known, likewise never included in the payload.
+	ScanCompletedAt *time.Time `json:"-"`
+}
+
+// NewScanNotification builds the notification payload for the given event on
+// an image.
+func NewScanNotification(
+	event string,
+	registry Registry,
+	repository types.Repository,
+	image types.ImageIdentifier,
+	findings map[string]int32,
+) ScanNotification {
+	return ScanNotification{
+		Event:       event,
+		Region:      registry.Region,
+		Repository:  *repository.RepositoryName,
+		ImageDigest: *image.ImageDigest,
+		ImageTag:    ImageTag(image),
+		Findings:    findings,
+		RegistryID:  aws.ToString(registry.ID),
+	}
+}
+
+// SNSNotifier publishes scan notifications to an AWS SNS topic.
+type SNSNotifier struct {
+	Client   *sns.Client
+	Metrics  *Metrics
+	TopicARN string
+}
+
+// NewSNSNotifier creates a notifier for the given topic from the AWS
+// configuration, targeting the region the topic lives in.
+func NewSNSNotifier(cfg aws.Config, metrics *Metrics, topic string) (*SNSNotifier, error) {
+	parsed, err := arn.Parse(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SNSNotifier{
+		Client: sns.NewFromConfig(cfg, func(o *sns.Options) {
+			o.Region = parsed.Region
+		}),
+		Metrics:  metrics,
+		TopicARN: topic,
+	}, nil
+}
+
+// Notify publishes the given notification to the topic. Failures are logged and
+// counted but never returned, as notifications must not abort reconciliation.
+func (n *SNSNotifier) Notify(ctx context.Context, notification ScanNotification) {
+	logger := log.WithFields(log.Fields{
+		"event":      notification.Event,
+		"region":     notification.Region,
+		"repository": notification.Repository,
+		"topic":      n.TopicARN,
+	})
+
+	message, err := json.Marshal(notification)
+	if err != nil {
+		logger.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to encode notification")
+		n.Metrics.RecordDelivery(ChannelSNS, err)
+		return
+	}
+
+	_, err = n.Client.Publish(ctx, &sns.PublishInput{
+		Message:  aws.String(string(message)),
+		TopicArn: aws.String(n.TopicARN),
+	})
+	if err != nil {
+		logger.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to publish notification")
+		n.Metrics.RecordDelivery(ChannelSNS, err)
+		return
+	}
+	n.Metrics.RecordDelivery(ChannelSNS, nil)
+	logger.Debug("published notification")
+}
+
+// EventBridgeNotifier puts an event onto an AWS EventBridge bus whenever a scan
+// is requested.
+type EventBridgeNotifier struct {
+	Client  *eventbridge.Client
+	Metrics *Metrics
+	BusName string
+}
+
+// NewEventBridgeNotifier creates a notifier for the given bus from the AWS
+// configuration.
+func NewEventBridgeNotifier(cfg aws.Config, metrics *Metrics, bus string) *EventBridgeNotifier {
+	return &EventBridgeNotifier{
+		Client:  eventbridge.NewFromConfig(cfg),
+		Metrics: metrics,
+		BusName: bus,
+	}
+}
+
+// Notify puts the given notification onto the bus if it's for a requested scan.
+// Failures are logged and counted but never returned, as notifications must not
+// abort reconciliation.
+func (n *EventBridgeNotifier) Notify(ctx context.Context, notification ScanNotification) {
+	if notification.Event != EventScanRequested {
+		return
+	}
+
+	logger := log.WithFields(log.Fields{
+		"bus":        n.BusName,
+		"region":     notification.Region,
+		"repository": notification.Repository,
+	})
+
+	detail, err := json.Marshal(notification)
+	if err != nil {
+		logger.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to encode event")
+		n.Metrics.RecordDelivery(ChannelEventBridge, err)
+		return
+	}
+
+	response, err := n.Client.PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []ebtypes.PutEventsRequestEntry{{
+			Detail:       aws.String(string(detail)),
+			DetailType:   aws.String(EventBridgeDetailType),
+			EventBusName: aws.String(n.BusName),
+			Source:       aws.String(EventBridgeSource),
+		}},
+	})
+	if err == nil && response.FailedEntryCount > 0 {
+		err = fmt.Errorf("%s", aws.ToString(response.Entries[0].ErrorMessage))
+	}
+	if err != nil {
+		logger.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to put event")
+		n.Metrics.RecordDelivery(ChannelEventBridge, err)
+		return
+	}
+	n.Metrics.RecordDelivery(ChannelEventBridge, nil)
+	logger.Debug("put event")
+}
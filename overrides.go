@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// RepositoryOverride overrides the settings of the repositories whose names
+// match its glob pattern. Settings left unset aren't overridden.
+type RepositoryOverride struct {
+	Pattern          string         `mapstructure:"pattern"`
+	Concurrency      *int           `mapstructure:"concurrency"`
+	MaxPerRepository *int           `mapstructure:"max_per_repository"`
+	MinInterval      *time.Duration `mapstructure:"min_interval"`
+	SkipUntagged     *bool          `mapstructure:"skip_untagged"`
+	TagExclude       []string       `mapstructure:"tag_exclude"`
+	TagInclude       []string       `mapstructure:"tag_include"`
+}
+
+// RepositoryConfig are the settings a single repository is reconciled with.
+type RepositoryConfig struct {
+	// Concurrency is the maximum number of images of the repository scanned
+	// at once, or zero if only bound by the image concurrency of the run.
+	Concurrency int
+
+	MaxPerRepository int
+	MinInterval      time.Duration
+	SkipUntagged     bool
+	TagExclude       []string
+	TagInclude       []string
+}
+
+// RepositoryOverrides returns the configured repository overrides, validating
+// their patterns.
+func RepositoryOverrides() ([]RepositoryOverride, error) {
+	var overrides []RepositoryOverride
+	if err := viper.UnmarshalKey("repository_overrides", &overrides); err != nil {
+		return nil, err
+	}
+	for i, override := range overrides {
+		if override.Pattern == "" {
+			return nil, fmt.Errorf("repository override %d has no pattern", i)
+		}
+		if _, err := path.Match(override.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q of repository override %d: %w", override.Pattern, i, err)
+		}
+		for _, patterns := range [][]string{override.TagExclude, override.TagInclude} {
+			if err := ValidatePatterns(patterns); err != nil {
+				return nil, fmt.Errorf("invalid tag patterns of repository override %d: %w", i, err)
+			}
+		}
+		if override.Concurrency != nil && *override.Concurrency < 0 {
+			return nil, fmt.Errorf("negative concurrency of repository override %d", i)
+		}
+	}
+	return overrides, nil
+}
+
+// PatternSpecificity ranks how specific a glob pattern is, by the number of
+// characters it matches literally. Patterns without any wildcards outrank
+// every other.
+func PatternSpecificity(pattern string) int {
+	wildcards := strings.Count(pattern, "*") + strings.Count(pattern, "?") + strings.Count(pattern, "[")
+	if wildcards == 0 {
+		return int(^uint(0) >> 1)
+	}
+	return len(pattern) - wildcards
+}
+
+// ResolveRepositoryConfig returns the settings of the repository with the
+// given name, merging the overrides matching it onto the global settings from
+// least to most specific, so that the most specific match wins. Overrides of
+// equal specificity apply in the order they're configured, the last winning.
+func ResolveRepositoryConfig(name string) RepositoryConfig {
+	config := RepositoryConfig{
+		MaxPerRepository: viper.GetInt("images.max_per_repository"),
+		MinInterval:      viper.GetDuration("scan.min_interval"),
+		SkipUntagged:     viper.GetBool("images.skip_untagged"),
+		TagExclude:       viper.GetStringSlice("images.tag_exclude"),
+		TagInclude:       viper.GetStringSlice("images.tag_include"),
+	}
+
+	// Our overrides are validated on startup.
+	overrides, _ := RepositoryOverrides()
+	var matching []RepositoryOverride
+	for _, override := range overrides {
+		if matched, _ := path.Match(override.Pattern, name); matched {
+			matching = append(matching, override)
+		}
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		return PatternSpecificity(matching[i].Pattern) < PatternSpecificity(matching[j].Pattern)
+	})
+
+	for _, override := range matching {
+		if override.Concurrency != nil {
+			config.Concurrency = *override.Concurrency
+		}
+		if override.MaxPerRepository != nil {
+			config.MaxPerRepository = *override.MaxPerRepository
+		}
+		if override.MinInterval != nil {
+			config.MinInterval = *override.MinInterval
+		}
+		if override.SkipUntagged != nil {
+			config.SkipUntagged = *override.SkipUntagged
+		}
+		if override.TagExclude != nil {
+			config.TagExclude = override.TagExclude
+		}
+		if override.TagInclude != nil {
+			config.TagInclude = override.TagInclude
+		}
+	}
+	return config
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResolveRepositoryConfig(t *testing.T) {
+	configure(t, map[string]interface{}{
+		"images.max_per_repository": 10,
+		"images.skip_untagged":      false,
+		"images.tag_exclude":        []string{"*-rc"},
+		"images.tag_include":        []string{},
+		"scan.min_interval":         "24h",
+		"repository_overrides": []map[string]interface{}{
+			{"pattern": "team-a/*", "max_per_repository": 5, "min_interval": "12h"},
+			{"pattern": "team-a/web*", "max_per_repository": 3, "tag_include": []string{"v*"}},
+			{"pattern": "team-a/web", "max_per_repository": 1},
+			{"pattern": "team-?/api", "skip_untagged": true, "concurrency": 2},
+			{"pattern": "team-b/*", "tag_exclude": []string{}},
+			{"pattern": "*/api", "min_interval": "1h"},
+			{"pattern": "team-c/*", "concurrency": 1},
+			{"pattern": "team-c/*", "concurrency": 4},
+		},
+	})
+	global := RepositoryConfig{
+		MaxPerRepository: 10,
+		MinInterval:      24 * time.Hour,
+		TagExclude:       []string{"*-rc"},
+		TagInclude:       []string{},
+	}
+	tests := []struct {
+		name       string
+		repository string
+		want       func(config RepositoryConfig) RepositoryConfig
+	}{
+		{
+			name:       "no match falls back to the global settings",
+			repository: "platform/api-gateway",
+			want:       func(config RepositoryConfig) RepositoryConfig { return config },
+		},
+		{
+			name:       "glob match",
+			repository: "team-a/worker",
+			want: func(config RepositoryConfig) RepositoryConfig {
+				config.MaxPerRepository = 5
+				config.MinInterval = 12 * time.Hour
+				return config
+			},
+		},
+		{
+			name:       "more specific glob wins over less specific",
+			repository: "team-a/website",
+			want: func(config RepositoryConfig) RepositoryConfig {
+				config.MaxPerRepository = 3
+				config.MinInterval = 12 * time.Hour
+				config.TagInclude = []string{"v*"}
+				return config
+			},
+		},
+		{
+			name:       "exact match wins over every glob",
+			repository: "team-a/web",
+			want: func(config RepositoryConfig) RepositoryConfig {
+				config.MaxPerRepository = 1
+				config.MinInterval = 12 * time.Hour
+				config.TagInclude = []string{"v*"}
+				return config
+			},
+		},
+		{
+			name:       "overlapping patterns merge their settings",
+			repository: "team-a/api",
+			want: func(config RepositoryConfig) RepositoryConfig {
+				config.Concurrency = 2
+				config.MaxPerRepository = 5
+				config.MinInterval = 12 * time.Hour
+				config.SkipUntagged = true
+				return config
+			},
+		},
+		{
+			name:       "less specific overlapping pattern still applies unset settings",
+			repository: "team-b/api",
+			want: func(config RepositoryConfig) RepositoryConfig {
+				config.Concurrency = 2
+				config.MinInterval = time.Hour
+				config.SkipUntagged = true
+				config.TagExclude = []string{}
+				return config
+			},
+		},
+		{
+			name:       "equally specific patterns apply in order",
+			repository: "team-c/api",
+			want: func(config RepositoryConfig) RepositoryConfig {
+				config.Concurrency = 2
+				config.MinInterval = time.Hour
+				config.SkipUntagged = true
+				return config
+			},
+		},
+		{
+			name:       "last of duplicate patterns wins",
+			repository: "team-c/worker",
+			want: func(config RepositoryConfig) RepositoryConfig {
+				config.Concurrency = 4
+				return config
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			want := test.want(global)
+			if got := ResolveRepositoryConfig(test.repository); !reflect.DeepEqual(got, want) {
+				t.Errorf("ResolveRepositoryConfig(%q) = %+v, want %+v", test.repository, got, want)
+			}
+		})
+	}
+}
+
+func TestRepositoryOverridesInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		override map[string]interface{}
+	}{
+		{name: "no pattern", override: map[string]interface{}{"max_per_repository": 1}},
+		{name: "invalid pattern", override: map[string]interface{}{"pattern": "team-[a"}},
+		{name: "invalid tag expression", override: map[string]interface{}{"pattern": "*", "tag_include": []string{`re:v(1`}}},
+		{name: "negative concurrency", override: map[string]interface{}{"pattern": "*", "concurrency": -1}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			configure(t, map[string]interface{}{
+				"repository_overrides": []map[string]interface{}{test.override},
+			})
+			if _, err := RepositoryOverrides(); err == nil {
+				t.Error("RepositoryOverrides() error = nil, want a validation error")
+			}
+		})
+	}
+}
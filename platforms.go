@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// batchGetImageBatchSize is the maximum number of image IDs AWS ECR accepts in
+// a single BatchGetImage request.
+const batchGetImageBatchSize = 100
+
+const (
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIImageIndex      = "application/vnd.oci.image.index.v1+json"
+)
+
+// manifestMediaTypes are the manifest media types we accept from AWS ECR, which
+// must include the manifest lists for them to be returned as they were pushed.
+var manifestMediaTypes = []string{
+	mediaTypeDockerManifestList,
+	mediaTypeOCIImageIndex,
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+// Platform is the OS and architecture, plus an optional variant, an image of a
+// manifest list is built for.
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// String returns the platform in its usual `os/architecture/variant` form.
+func (p Platform) String() string {
+	if p.Variant == "" {
+		return p.OS + "/" + p.Architecture
+	}
+	return p.OS + "/" + p.Architecture + "/" + p.Variant
+}
+
+// Matches reports whether the given platform is selected by this one, which
+// selects any variant of its architecture unless it has a variant itself.
+func (p Platform) Matches(platform Platform) bool {
+	return p.OS == platform.OS &&
+		p.Architecture == platform.Architecture &&
+		(p.Variant == "" || p.Variant == platform.Variant)
+}
+
+// ParsePlatform parses a platform of the form `os/architecture` or
+// `os/architecture/variant`, such as `linux/amd64` or `linux/arm64/v8`.
+func ParsePlatform(value string) (Platform, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Platform{}, fmt.Errorf("invalid platform %q, expected os/architecture[/variant]", value)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return Platform{}, fmt.Errorf("invalid platform %q, expected os/architecture[/variant]", value)
+		}
+	}
+	platform := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		platform.Variant = parts[2]
+	}
+	return platform, nil
+}
+
+// SelectedPlatforms returns the platforms configured to be scanned, any
+// platform being scanned if there are none.
+func SelectedPlatforms() ([]Platform, error) {
+	var platforms []Platform
+	for _, value := range viper.GetStringSlice("images.platforms") {
+		platform, err := ParsePlatform(value)
+		if err != nil {
+			return nil, err
+		}
+		platforms = append(platforms, platform)
+	}
+	return platforms, nil
+}
+
+// ManifestList is the subset of a Docker manifest list or OCI image index we
+// need to find the platform-specific images it refers to.
+type ManifestList struct {
+	MediaType string `json:"mediaType"`
+	Manifests []struct {
+		Digest   string    `json:"digest"`
+		Platform *Platform `json:"platform"`
+	} `json:"manifests"`
+}
+
+// ParseManifestList parses the given image manifest, reporting whether it's a
+// manifest list at all. The media type reported by AWS ECR is preferred over
+// the manifest's own, which OCI image indexes may leave out.
+func ParseManifestList(mediaType string, manifest string) (ManifestList, bool) {
+	var list ManifestList
+	if err := json.Unmarshal([]byte(manifest), &list); err != nil {
+		return list, false
+	}
+	if mediaType == "" {
+		mediaType = list.MediaType
+	}
+	switch mediaType {
+	case mediaTypeDockerManifestList, mediaTypeOCIImageIndex:
+		return list, true
+	case "":
+		// Only manifest lists refer to other manifests.
+		return list, len(list.Manifests) > 0
+	}
+	return list, false
+}
+
+// FetchManifestLists retrieves the manifests of the given images, returning the
+// manifest lists among them keyed by image digest.
+func FetchManifestLists(
+	ctx context.Context,
+	registry Registry,
+	repository types.Repository,
+	images []types.ImageIdentifier,
+) (map[string]ManifestList, error) {
+	// Retrieve each manifest by digest alone, and only once however many tags
+	// refer to it.
+	var ids []types.ImageIdentifier
+	seen := map[string]bool{}
+	for _, image := range images {
+		if !seen[*image.ImageDigest] {
+			seen[*image.ImageDigest] = true
+			ids = append(ids, types.ImageIdentifier{ImageDigest: image.ImageDigest})
+		}
+	}
+
+	lists := map[string]ManifestList{}
+	for start := 0; start < len(ids); start += batchGetImageBatchSize {
+		end := start + batchGetImageBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		response, err := registry.Client.BatchGetImage(ctx, &ecr.BatchGetImageInput{
+			AcceptedMediaTypes: manifestMediaTypes,
+			ImageIds:           ids[start:end],
+			RegistryId:         registry.ID,
+			RepositoryName:     repository.RepositoryName,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, image := range response.Images {
+			if image.ImageId == nil || image.ImageId.ImageDigest == nil {
+				continue
+			}
+			list, ok := ParseManifestList(
+				aws.ToString(image.ImageManifestMediaType),
+				aws.ToString(image.ImageManifest),
+			)
+			if ok {
+				lists[*image.ImageId.ImageDigest] = list
+			}
+		}
+	}
+	return lists, nil
+}
+
+// ExpandManifestLists replaces any multi-arch manifest lists among the given
+// images with the platform-specific images they refer to, as AWS ECR can only
+// scan the latter. Only the images of the given platforms are kept, if any,
+// while attestations and other images without a real platform never are.
+// Platform-specific images listed in their own right are left to be reached
+// through their manifest list, so that the platform selection applies to them.
+func ExpandManifestLists(
+	registry Registry,
+	repository types.Repository,
+	images []types.ImageIdentifier,
+	lists map[string]ManifestList,
+	platforms []Platform,
+) []types.ImageIdentifier {
+	if len(lists) == 0 {
+		return images
+	}
+
+	// Setup our logging context for the function.
+	logger := registry.Logger.WithFields(log.Fields{
+		"region":     registry.Region,
+		"repository": *repository.RepositoryName,
+	})
+
+	children := map[string]bool{}
+	for _, list := range lists {
+		for _, manifest := range list.Manifests {
+			children[manifest.Digest] = true
+		}
+	}
+
+	selected := func(platform *Platform) bool {
+		if platform == nil || platform.OS == "unknown" || platform.Architecture == "unknown" {
+			return false
+		}
+		if len(platforms) == 0 {
+			return true
+		}
+		for _, p := range platforms {
+			if p.Matches(*platform) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var expanded []types.ImageIdentifier
+	added := map[string]bool{}
+	for _, image := range images {
+		digest := *image.ImageDigest
+		list, ok := lists[digest]
+		if !ok {
+			if !children[digest] {
+				expanded = append(expanded, image)
+			}
+			continue
+		}
+
+		// Each tag of a manifest list refers to the same images, which we only
+		// need once.
+		if added[digest] {
+			continue
+		}
+		added[digest] = true
+		registry.Metrics.ManifestListsExpanded.WithLabelValues(registry.Region).Inc()
+
+		for _, manifest := range list.Manifests {
+			manifest := manifest
+			entry := logger.WithFields(log.Fields{
+				"image": map[string]string{
+					"digest": manifest.Digest,
+					"list":   digest,
+					"tag":    ImageTag(image),
+				},
+			})
+			if !selected(manifest.Platform) {
+				entry.Debug("platform not selected, skipping image of manifest list")
+				registry.Metrics.ImagesSkippedPlatform.WithLabelValues(registry.Region).Inc()
+				continue
+			}
+			if added[manifest.Digest] {
+				continue
+			}
+			added[manifest.Digest] = true
+			entry.WithFields(log.Fields{
+				"platform": manifest.Platform.String(),
+			}).Debug("scanning image of manifest list")
+			expanded = append(expanded, types.ImageIdentifier{ImageDigest: &manifest.Digest})
+		}
+	}
+	return expanded
+}
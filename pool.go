@@ -0,0 +1,33 @@
+package main
+
+// WorkerPool is a fixed-size pool of goroutines that executes submitted tasks,
+// bounding how many of them may run concurrently.
+type WorkerPool struct {
+	tasks chan func()
+}
+
+// NewWorkerPool creates a new pool and starts the given number of workers.
+func NewWorkerPool(size int) *WorkerPool {
+	pool := &WorkerPool{tasks: make(chan func())}
+	for i := 0; i < size; i++ {
+		go pool.work()
+	}
+	return pool
+}
+
+// Submit enqueues a task onto the pool, blocking until a worker picks it up.
+func (p *WorkerPool) Submit(task func()) {
+	p.tasks <- task
+}
+
+// Close stops the workers once they've finished their current tasks, after
+// which no more tasks may be submitted.
+func (p *WorkerPool) Close() {
+	close(p.tasks)
+}
+
+func (p *WorkerPool) work() {
+	for task := range p.tasks {
+		task()
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecrpublic"
+)
+
+const (
+	// PublicRegion is the only region the AWS ECR Public API is offered in.
+	PublicRegion = "us-east-1"
+
+	// PublicRegistryRegion labels the metrics of the public registry in place
+	// of its region, so that they're told apart from those of the private
+	// registry of the same region.
+	PublicRegistryRegion = "public"
+)
+
+// ECRPublicAPI is the subset of the AWS ECR Public API used by the operator,
+// satisfied by *ecrpublic.Client.
+type ECRPublicAPI interface {
+	DescribeImages(context.Context, *ecrpublic.DescribeImagesInput, ...func(*ecrpublic.Options)) (*ecrpublic.DescribeImagesOutput, error)
+	DescribeRepositories(context.Context, *ecrpublic.DescribeRepositoriesInput, ...func(*ecrpublic.Options)) (*ecrpublic.DescribeRepositoriesOutput, error)
+}
+
+// ReconcilePublicRegistry enumerates the repositories and images of the public
+// registry that our filters select. AWS ECR Public offers neither image scans
+// nor their findings, so there's nothing more we can do with them than count
+// them, and every image selected is accounted for as skipped.
+func ReconcilePublicRegistry(
+	ctx context.Context,
+	client ECRPublicAPI,
+	metrics *Metrics,
+	logger *log.Entry,
+) (RunResult, error) {
+	// Setup our logging context for the function.
+	logger = logger.WithFields(log.Fields{
+		"region": PublicRegistryRegion,
+	})
+	var id *string
+	if registry := viper.GetString("aws.registry_id"); registry != "" {
+		id = aws.String(registry)
+	}
+
+	logger.Debug("describing AWS ECR Public repositories")
+	var repositories []string
+	paginator := ecrpublic.NewDescribeRepositoriesPaginator(client, &ecrpublic.DescribeRepositoriesInput{
+		RegistryId: id,
+	})
+	for paginator.HasMorePages() {
+		response, err := paginator.NextPage(ctx)
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"err": err,
+			}).Warn("failed to describe AWS ECR Public repositories, skipping public registry")
+			return RunResult{}, err
+		}
+		for _, repository := range response.Repositories {
+			repositories = append(repositories, aws.ToString(repository.RepositoryName))
+		}
+	}
+
+	// Only the configured names are reconciled if there are any, and either
+	// way our repository filters apply.
+	names := map[string]bool{}
+	for _, name := range viper.GetStringSlice("repositories.names") {
+		names[name] = true
+	}
+	includes, ok := RepositoryIncludes()
+	if !ok {
+		repositories = nil
+	}
+
+	var result RunResult
+	var images int64
+	for _, name := range repositories {
+		if (len(names) > 0 && !names[name]) || !ShouldReconcileRepository(name, includes) {
+			logger.WithFields(log.Fields{
+				"repository": name,
+			}).Debug("repository filtered out, skipping")
+			continue
+		}
+		if ctx.Err() != nil {
+			result.Incomplete = append(result.Incomplete, name)
+			continue
+		}
+
+		found, selected, err := CountPublicImages(ctx, client, id, name)
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"err":        err,
+				"repository": name,
+			}).Error("failed to describe AWS ECR Public images, skipping")
+			result.Failures++
+			continue
+		}
+		logger.WithFields(log.Fields{
+			"images":     found,
+			"repository": name,
+			"selected":   selected,
+		}).Info("AWS ECR Public offers no image scans, only enumerated repository")
+		result.Repositories++
+		result.Images += found
+		result.Skipped += found
+		images += selected
+	}
+
+	// Only a run that got through the entire registry knows how large it is.
+	if ctx.Err() == nil {
+		metrics.RepositoriesDiscovered.WithLabelValues(PublicRegistryRegion, "enumerated").Set(float64(len(repositories)))
+		metrics.RepositoriesDiscovered.WithLabelValues(PublicRegistryRegion, "selected").Set(float64(result.Repositories))
+		metrics.ImagesDiscovered.WithLabelValues(PublicRegistryRegion, "enumerated").Set(float64(result.Images))
+		metrics.ImagesDiscovered.WithLabelValues(PublicRegistryRegion, "selected").Set(float64(images))
+	}
+	return result, nil
+}
+
+// CountPublicImages counts the images of the public repository, along with how
+// many of them our image filters select. An image is selected if any of its
+// tags is.
+func CountPublicImages(
+	ctx context.Context,
+	client ECRPublicAPI,
+	id *string,
+	repository string,
+) (int64, int64, error) {
+	config := ResolveRepositoryConfig(repository)
+	paginator := ecrpublic.NewDescribeImagesPaginator(client, &ecrpublic.DescribeImagesInput{
+		RegistryId:     id,
+		RepositoryName: aws.String(repository),
+	})
+
+	var found, selected int64
+	for paginator.HasMorePages() {
+		response, err := paginator.NextPage(ctx)
+		if err != nil {
+			return found, selected, err
+		}
+		for _, detail := range response.ImageDetails {
+			found++
+
+			// Our filters apply to the images as AWS ECR lists them, once for
+			// each of their tags.
+			ids := []types.ImageIdentifier{{ImageDigest: detail.ImageDigest}}
+			if len(detail.ImageTags) > 0 {
+				ids = nil
+				for _, tag := range detail.ImageTags {
+					ids = append(ids, types.ImageIdentifier{
+						ImageDigest: detail.ImageDigest,
+						ImageTag:    aws.String(tag),
+					})
+				}
+			}
+			for _, image := range ids {
+				if ShouldReconcileImage(image, config) {
+					selected++
+					break
+				}
+			}
+		}
+	}
+	return found, selected, nil
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+
+	"github.com/celestialorb/aws-ecr-scan-operator/ecrfake"
+)
+
+// Ensure our fake keeps up with the AWS ECR API surface we use.
+var _ ECRAPI = (*ecrfake.Client)(nil)
+
+// fakeRegistry returns a fake with the given number of repositories, each with
+// the given number of tagged images.
+func fakeRegistry(repositories int, images int) *ecrfake.Client {
+	client := &ecrfake.Client{Images: map[string][]types.ImageIdentifier{}}
+	for r := 0; r < repositories; r++ {
+		name := fmt.Sprintf("repository-%d", r)
+		client.Repositories = append(client.Repositories, types.Repository{
+			RepositoryArn:  aws.String("arn:aws:ecr:us-east-1:123456789012:repository/" + name),
+			RepositoryName: aws.String(name),
+		})
+		for i := 0; i < images; i++ {
+			client.Images[name] = append(client.Images[name], types.ImageIdentifier{
+				ImageDigest: aws.String(fmt.Sprintf("sha256:%d%d", r, i)),
+				ImageTag:    aws.String(fmt.Sprintf("v%d", i)),
+			})
+		}
+	}
+	return client
+}
+
+func TestReconcileRegistryPaginates(t *testing.T) {
+	configure(t, map[string]interface{}{"scan.min_interval": 0})
+	client := fakeRegistry(3, 3)
+	client.PageSize = 2
+	pool := NewWorkerPool(2)
+	defer pool.Close()
+
+	result, err := ReconcileRegistry(context.Background(), testRegistry(client), pool)
+	if err != nil {
+		t.Fatalf("ReconcileRegistry() error = %v", err)
+	}
+	if result.Repositories != 3 || result.Images != 9 || result.Requested != 9 || result.Failures != 0 {
+		t.Errorf("ReconcileRegistry() = %+v, want 3 repositories and 9 images requested", result)
+	}
+	if got := len(client.Scanned()); got != 9 {
+		t.Errorf("scanned %d images, want 9", got)
+	}
+	if got := client.Calls("DescribeRepositories"); got != 2 {
+		t.Errorf("DescribeRepositories called %d times, want 2", got)
+	}
+	if got := client.Calls("ListImages"); got != 6 {
+		t.Errorf("ListImages called %d times, want 6", got)
+	}
+}
+
+func TestReconcileRepositoryLimitExceeded(t *testing.T) {
+	configure(t, map[string]interface{}{"scan.min_interval": 0})
+	client := fakeRegistry(1, 3)
+	client.InjectError("StartImageScan", 2, &types.LimitExceededException{Message: aws.String("quota exceeded")})
+	registry := testRegistry(client)
+	pool := NewWorkerPool(1)
+	defer pool.Close()
+
+	result := ReconcileRepository(context.Background(), registry, pool, client.Repositories[0])
+	if result.Requested != 2 || result.RateLimited != 1 || result.Failures != 0 {
+		t.Errorf("ReconcileRepository() = %+v, want 2 requested and 1 rate-limited", result)
+	}
+	if got := testutil.ToFloat64(registry.Metrics.ScansRateLimited); got != 1 {
+		t.Errorf("aws_ecr_scans_rate_limited = %v, want 1", got)
+	}
+}
+
+func TestReconcileRepositoryErrors(t *testing.T) {
+	configure(t, map[string]interface{}{"scan.min_interval": 0})
+	tests := []struct {
+		name      string
+		operation string
+		call      int
+		err       error
+		want      RunResult
+		scanned   int
+	}{
+		{
+			name:      "listing images fails partway",
+			operation: "ListImages",
+			call:      2,
+			err:       errors.New("internal error"),
+			want:      RunResult{Failures: 1, Images: 1},
+		},
+		{
+			name:      "repository deleted while listing",
+			operation: "ListImages",
+			call:      1,
+			err:       &types.RepositoryNotFoundException{Message: aws.String("repository does not exist")},
+			want:      RunResult{},
+		},
+		{
+			name:      "scan request fails",
+			operation: "StartImageScan",
+			call:      1,
+			err:       errors.New("internal error"),
+			want:      RunResult{Failures: 1, Images: 3, Requested: 2},
+			scanned:   2,
+		},
+		{
+			name:      "image deleted since listed",
+			operation: "StartImageScan",
+			call:      3,
+			err:       &types.ImageNotFoundException{Message: aws.String("image does not exist")},
+			want:      RunResult{Images: 3, Requested: 2},
+			scanned:   2,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			client := fakeRegistry(1, 3)
+			client.PageSize = 1
+			client.InjectError(test.operation, test.call, test.err)
+			pool := NewWorkerPool(1)
+			defer pool.Close()
+
+			result := ReconcileRepository(context.Background(), testRegistry(client), pool, client.Repositories[0])
+			if result.Failures != test.want.Failures || result.Images != test.want.Images || result.Requested != test.want.Requested {
+				t.Errorf("ReconcileRepository() = %+v, want %+v", result, test.want)
+			}
+			if got := len(client.Scanned()); got != test.scanned {
+				t.Errorf("scanned %d images, want %d", got, test.scanned)
+			}
+		})
+	}
+}
+
+func TestReconcileRegistryDescribeRepositoriesFailsPartway(t *testing.T) {
+	configure(t, map[string]interface{}{"scan.min_interval": 0})
+	client := fakeRegistry(3, 1)
+	client.PageSize = 1
+	client.InjectError("DescribeRepositories", 2, errors.New("internal error"))
+	pool := NewWorkerPool(1)
+	defer pool.Close()
+
+	// The repositories described before the failure are still reconciled.
+	result, err := ReconcileRegistry(context.Background(), testRegistry(client), pool)
+	if err == nil {
+		t.Error("ReconcileRegistry() error = nil, want the DescribeRepositories error")
+	}
+	if result.Repositories != 1 {
+		t.Errorf("ReconcileRegistry() reconciled %d repositories, want 1", result.Repositories)
+	}
+	if got := len(client.Scanned()); got != 1 {
+		t.Errorf("scanned %d images, want 1", got)
+	}
+}
+
+func TestReconcileRepositoryExpandsManifestLists(t *testing.T) {
+	configure(t, map[string]interface{}{
+		"images.expand_manifest_lists": true,
+		"scan.min_interval":            0,
+	})
+	client := fakeRegistry(1, 0)
+	name := *client.Repositories[0].RepositoryName
+	client.Images[name] = []types.ImageIdentifier{
+		{ImageDigest: aws.String("sha256:list"), ImageTag: aws.String("latest")},
+		{ImageDigest: aws.String("sha256:list"), ImageTag: aws.String("v1")},
+		{ImageDigest: aws.String("sha256:plain"), ImageTag: aws.String("v0")},
+	}
+	client.Manifests = map[string]string{"sha256:list": `{
+		"mediaType": "application/vnd.oci.image.index.v1+json",
+		"manifests": [
+			{"digest": "sha256:amd64", "platform": {"os": "linux", "architecture": "amd64"}},
+			{"digest": "sha256:arm64", "platform": {"os": "linux", "architecture": "arm64"}},
+			{"digest": "sha256:s390x", "platform": {"os": "linux", "architecture": "s390x"}}
+		]
+	}`}
+	pool := NewWorkerPool(1)
+	defer pool.Close()
+
+	// The manifest list's two tags become the three platform-specific images it
+	// refers to, which count as found rather than making the skips negative.
+	result := ReconcileRepository(context.Background(), testRegistry(client), pool, client.Repositories[0])
+	if result.Images != 4 || result.Skipped != 0 || result.Requested != 4 {
+		t.Errorf("ReconcileRepository() = %+v, want 4 images found and requested, none skipped", result)
+	}
+}
+
+func TestReconcileRepositoryCountsSkips(t *testing.T) {
+	configure(t, map[string]interface{}{
+		"images.tag_exclude": []string{"v0"},
+		"scan.min_interval":  0,
+	})
+	client := fakeRegistry(1, 3)
+	name := *client.Repositories[0].RepositoryName
+	client.Images[name] = append(client.Images[name], types.ImageIdentifier{
+		ImageDigest: client.Images[name][1].ImageDigest,
+		ImageTag:    aws.String("latest"),
+	})
+	pool := NewWorkerPool(1)
+	defer pool.Close()
+
+	// One image is filtered out by its tag and another tag of an image is left
+	// out as a duplicate.
+	result := ReconcileRepository(context.Background(), testRegistry(client), pool, client.Repositories[0])
+	if result.Images != 4 || result.Skipped != 2 || result.Requested != 2 {
+		t.Errorf("ReconcileRepository() = %+v, want 4 images found, 2 skipped and 2 requested", result)
+	}
+}
+
+// inFlightClient is a fake that takes its time over listing images and
+// requesting scans, keeping track of the most scan requests and repositories
+// it has had in flight at once.
+type inFlightClient struct {
+	*ecrfake.Client
+
+	mutex        sync.Mutex
+	scans        int
+	repositories map[string]int
+	maxScans     int
+	maxRepos     int
+}
+
+// track counts the call on the repository in flight until it's done.
+func (c *inFlightClient) track(repository string, scan bool) (done func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.repositories == nil {
+		c.repositories = map[string]int{}
+	}
+	c.repositories[repository]++
+	if len(c.repositories) > c.maxRepos {
+		c.maxRepos = len(c.repositories)
+	}
+	if scan {
+		c.scans++
+		if c.scans > c.maxScans {
+			c.maxScans = c.scans
+		}
+	}
+	return func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if c.repositories[repository]--; c.repositories[repository] == 0 {
+			delete(c.repositories, repository)
+		}
+		if scan {
+			c.scans--
+		}
+	}
+}
+
+func (c *inFlightClient) ListImages(
+	ctx context.Context,
+	input *ecr.ListImagesInput,
+	opts ...func(*ecr.Options),
+) (*ecr.ListImagesOutput, error) {
+	defer c.track(aws.ToString(input.RepositoryName), false)()
+	time.Sleep(10 * time.Millisecond)
+	return c.Client.ListImages(ctx, input, opts...)
+}
+
+func (c *inFlightClient) StartImageScan(
+	ctx context.Context,
+	input *ecr.StartImageScanInput,
+	opts ...func(*ecr.Options),
+) (*ecr.StartImageScanOutput, error) {
+	defer c.track(aws.ToString(input.RepositoryName), true)()
+	time.Sleep(10 * time.Millisecond)
+	return c.Client.StartImageScan(ctx, input, opts...)
+}
+
+func TestReconcileRegistryConcurrencyLimits(t *testing.T) {
+	tests := []struct {
+		name         string
+		repositories int
+		images       int
+	}{
+		{name: "fewer repositories than images", repositories: 2, images: 3},
+		{name: "more repositories than images", repositories: 3, images: 2},
+		{name: "one of each", repositories: 1, images: 1},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			configure(t, map[string]interface{}{
+				"scan.min_interval":           0,
+				"scan.repository_concurrency": test.repositories,
+			})
+			client := &inFlightClient{Client: fakeRegistry(6, 4)}
+			pool := NewWorkerPool(test.images)
+			defer pool.Close()
+
+			result, err := ReconcileRegistry(context.Background(), testRegistry(client), pool)
+			if err != nil {
+				t.Fatalf("ReconcileRegistry() error = %v", err)
+			}
+			if result.Requested != 24 {
+				t.Errorf("ReconcileRegistry() requested %d scans, want 24", result.Requested)
+			}
+
+			// Each limit is reached but never exceeded, regardless of the other.
+			if client.maxRepos != test.repositories {
+				t.Errorf("reconciled up to %d repositories at once, want %d", client.maxRepos, test.repositories)
+			}
+			if client.maxScans != test.images {
+				t.Errorf("requested up to %d scans at once, want %d", client.maxScans, test.images)
+			}
+		})
+	}
+}
+
+func TestReconcileRepositoryUntagged(t *testing.T) {
+	configure(t, map[string]interface{}{
+		"images.skip_untagged": false,
+		"scan.min_interval":    0,
+	})
+	client := fakeRegistry(1, 1)
+	client.Images["repository-0"] = append(client.Images["repository-0"], types.ImageIdentifier{
+		ImageDigest: aws.String("sha256:untagged"),
+	})
+	pool := NewWorkerPool(1)
+	defer pool.Close()
+
+	result := ReconcileRepository(context.Background(), testRegistry(client), pool, client.Repositories[0])
+	if result.Requested != 2 || result.Failures != 0 {
+		t.Errorf("ReconcileRepository() = %+v, want both images requested", result)
+	}
+}
+
+func TestReconcileRepositoryCountsSkipsWhenListingFails(t *testing.T) {
+	configure(t, map[string]interface{}{
+		"images.tag_exclude": []string{"v0"},
+		"scan.min_interval":  0,
+	})
+	client := fakeRegistry(1, 3)
+	client.PageSize = 1
+	client.InjectError("ListImages", 2, errors.New("internal error"))
+	pool := NewWorkerPool(1)
+	defer pool.Close()
+
+	// The image filtered out before listing failed is still accounted for.
+	result := ReconcileRepository(context.Background(), testRegistry(client), pool, client.Repositories[0])
+	if result.Failures != 1 || result.Images != 1 || result.Skipped != 1 {
+		t.Errorf("ReconcileRepository() = %+v, want 1 image found and skipped, and a failure", result)
+	}
+}
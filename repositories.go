@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// DescribeRepositories retrieves every repository in the given registry. If a
+// page fails to be retrieved, the repositories described so far are returned
+// along with the error.
+func DescribeRepositories(ctx context.Context, registry Registry) ([]types.Repository, error) {
+	// Create a paginator for describing repositories in case we have a lot.
+	paginator := ecr.NewDescribeRepositoriesPaginator(
+		registry.Client,
+		&ecr.DescribeRepositoriesInput{RegistryId: registry.ID},
+	)
+
+	var repositories []types.Repository
+	for paginator.HasMorePages() {
+		// Stop paging once the run is cancelled, leaving the caller with the
+		// repositories described so far.
+		if err := ctx.Err(); err != nil {
+			return repositories, err
+		}
+		response, err := paginator.NextPage(ctx)
+		if err != nil {
+			return repositories, err
+		}
+		repositories = append(repositories, response.Repositories...)
+	}
+	return repositories, nil
+}
+
+// RepositoriesFromNames constructs repositories from the given names, allowing
+// us to skip describing the registry's repositories altogether. Blank names
+// are ignored.
+func RepositoriesFromNames(names []string) []types.Repository {
+	var repositories []types.Repository
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		repositories = append(repositories, types.Repository{
+			RepositoryName: aws.String(name),
+		})
+	}
+	return repositories
+}
+
+// ParseTagSelector parses a `key=value` resource tag selector.
+func ParseTagSelector(selector string) (string, string, error) {
+	key, value, ok := strings.Cut(selector, "=")
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("invalid tag selector %q, expected key=value", selector)
+	}
+	return key, value, nil
+}
+
+// TagCache remembers the resource tags of repositories throughout a run, so
+// that each repository's tags are only listed once.
+type TagCache struct {
+	mutex sync.Mutex
+	tags  map[string]map[string]string
+}
+
+// NewTagCache creates an empty tag cache.
+func NewTagCache() *TagCache {
+	return &TagCache{tags: map[string]map[string]string{}}
+}
+
+// RepositoryTags returns the resource tags of the given repository, listing
+// them unless they're already cached.
+func (c *TagCache) RepositoryTags(
+	ctx context.Context,
+	registry Registry,
+	repository types.Repository,
+) (map[string]string, error) {
+	arn := aws.ToString(repository.RepositoryArn)
+	c.mutex.Lock()
+	tags, ok := c.tags[arn]
+	c.mutex.Unlock()
+	if ok {
+		return tags, nil
+	}
+
+	response, err := registry.Client.ListTagsForResource(ctx, &ecr.ListTagsForResourceInput{
+		ResourceArn: repository.RepositoryArn,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tags = map[string]string{}
+	for _, tag := range response.Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	c.mutex.Lock()
+	c.tags[arn] = tags
+	c.mutex.Unlock()
+	return tags, nil
+}
+
+// IsSelectedByTag reports whether the repository carries the resource tag of
+// the configured selector, if there is one. Repositories given by name rather
+// than described are always selected, as their ARN isn't known.
+func IsSelectedByTag(ctx context.Context, registry Registry, repository types.Repository) (bool, error) {
+	selector := viper.GetString("repositories.select_by_tag")
+	if selector == "" || repository.RepositoryArn == nil {
+		return true, nil
+	}
+	key, value, err := ParseTagSelector(selector)
+	if err != nil {
+		return false, err
+	}
+
+	tags, err := registry.Tags.RepositoryTags(ctx, registry, repository)
+	if err != nil {
+		return false, err
+	}
+	actual, ok := tags[key]
+	return ok && actual == value, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// RunResult summarizes the outcome of reconciling part or all of a run, and is
+// merged together as the reconciliation of each image, repository and registry
+// finishes.
+type RunResult struct {
+	// DryRun is the number of scans that would have been requested if not for
+	// dry-run mode.
+	DryRun int64
+
+	// Enqueued is the number of scan tasks enqueued for workers to request.
+	Enqueued int64
+
+	// Failures is the number of errors encountered throughout the run.
+	Failures int64
+
+	// Images is the number of images found in the reconciled repositories.
+	Images int64
+
+	// Incomplete are the names of the repositories that didn't complete before
+	// the run was cancelled.
+	Incomplete []string
+
+	// OverBudget is the number of scans skipped as the run exhausted its
+	// budget.
+	OverBudget int64
+
+	// OverThreshold is the number of images with findings at or above the
+	// configured fail threshold.
+	OverThreshold int64
+
+	// RateLimited is the number of scan requests rejected due to rate-limiting.
+	RateLimited int64
+
+	// Reconciled is the number of registries whose repositories were described
+	// without error.
+	Reconciled int
+
+	// Repositories is the number of repositories reconciled.
+	Repositories int64
+
+	// Requested is the number of scans successfully requested.
+	Requested int64
+
+	// Skipped is the number of images found but left out before requesting
+	// their scans, whether filtered out, deduplicated, scanned recently or
+	// continuously scanned.
+	Skipped int64
+
+	// Unsupported is the number of scan requests rejected as AWS ECR can't
+	// scan the image.
+	Unsupported int64
+}
+
+// Merge adds the outcome of another result into this one.
+func (r *RunResult) Merge(other RunResult) {
+	r.DryRun += other.DryRun
+	r.Enqueued += other.Enqueued
+	r.Failures += other.Failures
+	r.Images += other.Images
+	r.Incomplete = append(r.Incomplete, other.Incomplete...)
+	r.OverBudget += other.OverBudget
+	r.OverThreshold += other.OverThreshold
+	r.RateLimited += other.RateLimited
+	r.Reconciled += other.Reconciled
+	r.Repositories += other.Repositories
+	r.Requested += other.Requested
+	r.Skipped += other.Skipped
+	r.Unsupported += other.Unsupported
+}
+
+// Succeeded reports whether the run managed to reconcile any registry at all,
+// regardless of individual image failures.
+func (r RunResult) Succeeded() bool {
+	return r.Reconciled > 0
+}
+
+// RateLimitedRatio returns the proportion of the run's scan requests that were
+// rejected due to rate-limiting, or zero if it made none.
+func (r RunResult) RateLimitedRatio() float64 {
+	attempts := r.Requested + r.RateLimited
+	if attempts == 0 {
+		return 0
+	}
+	return float64(r.RateLimited) / float64(attempts)
+}
+
+// Fields returns the result as logging fields.
+func (r RunResult) Fields() log.Fields {
+	return log.Fields{
+		"dryRun":       r.DryRun,
+		"enqueued":     r.Enqueued,
+		"failures":     r.Failures,
+		"images":       r.Images,
+		"overBudget":   r.OverBudget,
+		"rateLimited":  r.RateLimited,
+		"repositories": r.Repositories,
+		"requested":    r.Requested,
+		"skipped":      r.Skipped,
+		"unsupported":  r.Unsupported,
+	}
+}
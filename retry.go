@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/smithy-go"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	log "github.com/sirupsen/logrus"
+)
+
+// RetryingClient wraps an AWS ECR client, retrying transient errors of the
+// calls made during reconciliation with exponential backoff and jitter.
+type RetryingClient struct {
+	ECRAPI
+
+	Metrics     *Metrics
+	Region      string
+	MaxAttempts int
+	BaseDelay   time.Duration
+}
+
+func (c RetryingClient) DescribeRepositories(
+	ctx context.Context,
+	input *ecr.DescribeRepositoriesInput,
+	opts ...func(*ecr.Options),
+) (output *ecr.DescribeRepositoriesOutput, err error) {
+	err = c.retry(ctx, "DescribeRepositories", func() error {
+		output, err = c.ECRAPI.DescribeRepositories(ctx, input, opts...)
+		return err
+	})
+	return output, err
+}
+
+func (c RetryingClient) ListImages(
+	ctx context.Context,
+	input *ecr.ListImagesInput,
+	opts ...func(*ecr.Options),
+) (output *ecr.ListImagesOutput, err error) {
+	err = c.retry(ctx, "ListImages", func() error {
+		output, err = c.ECRAPI.ListImages(ctx, input, opts...)
+		return err
+	})
+	return output, err
+}
+
+func (c RetryingClient) StartImageScan(
+	ctx context.Context,
+	input *ecr.StartImageScanInput,
+	opts ...func(*ecr.Options),
+) (output *ecr.StartImageScanOutput, err error) {
+	err = c.retry(ctx, "StartImageScan", func() error {
+		output, err = c.ECRAPI.StartImageScan(ctx, input, opts...)
+		return err
+	})
+	return output, err
+}
+
+// retry invokes the given call until it succeeds, returns a non-transient
+// error, or the maximum number of attempts is reached.
+func (c RetryingClient) retry(ctx context.Context, operation string, call func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = call()
+		if err == nil || attempt >= c.MaxAttempts || !IsRetryable(err) {
+			return err
+		}
+
+		// Back off exponentially with full jitter before the next attempt.
+		delay := c.BaseDelay << (attempt - 1)
+		if delay > 0 {
+			delay = time.Duration(rand.Int63n(int64(delay)))
+		}
+		log.WithFields(log.Fields{
+			"attempt":   attempt,
+			"delay":     delay,
+			"err":       err,
+			"operation": operation,
+			"region":    c.Region,
+		}).Debug("transient AWS ECR API error, retrying")
+		c.Metrics.APIRetries.WithLabelValues(c.Region, operation).Inc()
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// IsRetryable reports whether the given AWS ECR API error is transient and the
+// request is worth retrying.
+func IsRetryable(err error) bool {
+	// Never retry a rate-limited scan request, it means the image has already
+	// been scanned within the last twenty-four hours.
+	var lee *types.LimitExceededException
+	if errors.As(err, &lee) {
+		return false
+	}
+
+	// Server-side errors are generally transient.
+	var se *types.ServerException
+	if errors.As(err, &se) {
+		return true
+	}
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) && re.HTTPStatusCode() >= 500 {
+		return true
+	}
+
+	// Throttling is transient by definition.
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		switch ae.ErrorCode() {
+		case "ThrottlingException", "ThrottledException", "RequestLimitExceeded", "TooManyRequestsException":
+			return true
+		}
+	}
+
+	// Lastly, network timeouts are worth another attempt.
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// NewRunID generates the ID of a run, which every log of the run carries so
+// that the logs of overlapping runs can be told apart. It's the time the run
+// started along with a random suffix, so that run IDs sort by when they ran.
+func NewRunID() string {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return time.Now().UTC().Format("20060102T150405.000000000Z")
+	}
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TriggerTokenHeader is the header holding the shared secret required to
+// trigger a run on demand, if one is configured.
+const TriggerTokenHeader = "X-Trigger-Token"
+
+const (
+	// TriggerSchedule is the source of the runs triggered by our schedule.
+	TriggerSchedule = "schedule"
+
+	// TriggerStartup is the source of the run triggered on startup.
+	TriggerStartup = "startup"
+
+	// TriggerOnDemand is the source of the runs triggered through the
+	// webserver.
+	TriggerOnDemand = "on_demand"
+)
+
+// TriggerOutcome is what became of a request for a run.
+type TriggerOutcome int
+
+const (
+	// TriggerStarted means the run started right away.
+	TriggerStarted TriggerOutcome = iota
+
+	// TriggerQueued means the run was queued to follow the one in progress.
+	TriggerQueued
+
+	// TriggerCoalesced means a run was already queued, which the request was
+	// folded into.
+	TriggerCoalesced
+)
+
+// Runner runs scans, whether scheduled or on demand, ensuring only a single run
+// is in progress at any time and recording the result of each. Runs requested
+// while one is in progress are queued in a single slot to follow it, and any
+// further requests are coalesced into the queued run rather than stacking.
+type Runner struct {
+	// Context is the operator's own, which runs triggered on demand are bound
+	// to so that they're cancelled on shutdown like any other.
+	Context context.Context
+
+	// Pool is the worker pool all image scan requests are funneled through.
+	Pool *WorkerPool
+
+	// State remembers when scans were last requested, if anywhere.
+	State StateStore
+
+	// Queue is where scan tasks are enqueued in the producer role, if anywhere.
+	Queue *WorkQueue
+
+	// Deferred retries the scans of rate-limited images, if at all.
+	Deferred *DeferredScans
+
+	// Findings collects the findings of recent scans between runs, if at all.
+	Findings *FindingsCollector
+
+	// Metrics are where each run is recorded.
+	Metrics *Metrics
+
+	// Summaries are where the summary of each run is delivered.
+	Summaries []RunNotifier
+
+	// Health tracks our readiness, which reflects the result of each run.
+	Health *Health
+
+	// Leader tracks whether we're the replica allowed to run scans.
+	Leader *Leader
+
+	mutex   sync.Mutex
+	running bool
+	pending *pendingRun
+}
+
+// pendingRun is a run queued to follow the one in progress.
+type pendingRun struct {
+	ctx   context.Context
+	delay time.Duration
+}
+
+// Run runs the scans after the given delay, blocking until the run and any run
+// queued behind it finish, unless a run is already in progress, in which case
+// it's queued or coalesced and Run returns right away.
+func (r *Runner) Run(ctx context.Context, delay time.Duration, source string) TriggerOutcome {
+	outcome := r.request(ctx, delay, source)
+	if outcome == TriggerStarted {
+		r.drain(ctx, delay)
+	}
+	return outcome
+}
+
+// Start runs the scans in the background, unless a run is already in
+// progress, in which case it's queued or coalesced.
+func (r *Runner) Start(ctx context.Context, source string) TriggerOutcome {
+	outcome := r.request(ctx, 0, source)
+	if outcome == TriggerStarted {
+		go r.drain(ctx, 0)
+	}
+	return outcome
+}
+
+// request claims the run if none is in progress, or otherwise queues it in the
+// free slot or coalesces it into the run already queued.
+func (r *Runner) request(ctx context.Context, delay time.Duration, source string) TriggerOutcome {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	switch {
+	case !r.running:
+		r.running = true
+		return TriggerStarted
+	case r.pending == nil:
+		r.pending = &pendingRun{ctx: ctx, delay: delay}
+		log.WithFields(log.Fields{
+			"source": source,
+		}).Info("run already in progress, queueing run")
+		return TriggerQueued
+	default:
+		r.Metrics.TriggersCoalesced.WithLabelValues(source).Inc()
+		log.WithFields(log.Fields{
+			"source": source,
+		}).Info("run already queued, coalescing run")
+		return TriggerCoalesced
+	}
+}
+
+// drain runs the scans, followed by the queued run for as long as there is
+// one, releasing the claim on the run once there's none.
+func (r *Runner) drain(ctx context.Context, delay time.Duration) {
+	for {
+		r.run(ctx, delay)
+
+		r.mutex.Lock()
+		pending := r.pending
+		r.pending = nil
+		if pending == nil {
+			r.running = false
+			r.mutex.Unlock()
+			return
+		}
+		r.mutex.Unlock()
+		ctx, delay = pending.ctx, pending.delay
+	}
+}
+
+// run waits out the delay and runs the scans, applying our failure policy to
+// the result.
+func (r *Runner) run(ctx context.Context, delay time.Duration) {
+	// Wait out our delay before starting so we don't all start at once.
+	if delay > 0 {
+		log.WithFields(log.Fields{
+			"delay": delay,
+		}).Debug("delaying run by jitter")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+
+	result := TriggerScans(ctx, r.Metrics, r.Pool, r.State, r.Queue, r.Deferred, r.Findings, r.Summaries, r.Health)
+	r.Health.Record(result)
+
+	// Under the crash policy a run with failures takes us down once it's
+	// finished, leaving it to our orchestration to react.
+	if r.Health.Policy == FailurePolicyCrash && result.Failures > 0 {
+		log.WithFields(log.Fields{
+			"failures": result.Failures,
+		}).Error("run finished with failures, exiting as per the failure policy")
+		Exit(ExitRunFailed)
+	}
+}
+
+// ServeTrigger starts a run on demand, responding with 202 whether it started,
+// was queued behind the run in progress, or was coalesced into the run already
+// queued, as a run follows the request either way. If a trigger token is
+// configured the request must carry it.
+func (r *Runner) ServeTrigger(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := viper.GetString("web.trigger_token")
+	if token != "" && subtle.ConstantTimeCompare([]byte(req.Header.Get(TriggerTokenHeader)), []byte(token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Replicas standing by must leave running scans to the leader.
+	if !r.Leader.IsLeader() {
+		http.Error(w, "not the leader", http.StatusServiceUnavailable)
+		return
+	}
+
+	// The run outlives the request, so it's bound to the operator instead.
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if r.Start(ctx, TriggerOnDemand) == TriggerStarted {
+		log.Info("run triggered on demand")
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
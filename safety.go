@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SafetyLimit is a circuit breaker on the number of images enumerated
+// throughout a run, aborting the run once they exceed it rather than issuing
+// however many AWS API calls a runaway registry would take. Unlike the scan
+// budget it's never expected to be reached. It's shared by every repository of
+// the run, so it must be safe for concurrent use.
+type SafetyLimit struct {
+	max    int64
+	seen   int64
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+// NewSafetyLimit creates the limit of the given number of images, which
+// cancels the run when exceeded, or nil if the number of images is unlimited.
+func NewSafetyLimit(max int64, cancel context.CancelFunc) *SafetyLimit {
+	if max <= 0 {
+		return nil
+	}
+	return &SafetyLimit{max: max, cancel: cancel}
+}
+
+// Add counts images listed in a repository of the registry towards the limit,
+// reporting whether the run may carry on. The first time the limit is exceeded
+// the run is aborted. A nil limit is never exceeded.
+func (l *SafetyLimit) Add(registry Registry, images int) bool {
+	if l == nil {
+		return true
+	}
+	if atomic.AddInt64(&l.seen, int64(images)) <= l.max {
+		return true
+	}
+	l.once.Do(func() {
+		registry.Metrics.SafetyAborts.Inc()
+		registry.Logger.WithFields(log.Fields{
+			"limit":  l.max,
+			"region": registry.Region,
+		}).Error("run enumerated more images than the safety limit allows, aborting the run, raise safety.max_total_images if this is expected")
+		l.cancel()
+	})
+	return false
+}
+
+// Exceeded reports whether the limit was exceeded, aborting the run.
+func (l *SafetyLimit) Exceeded() bool {
+	return l != nil && atomic.LoadInt64(&l.seen) > l.max
+}
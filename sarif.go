@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// sarifSchema is the JSON schema of the SARIF version we produce.
+	sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+	// sarifVersion is the SARIF version we produce.
+	sarifVersion = "2.1.0"
+)
+
+// SARIFEnabled reports whether findings should be rendered as SARIF.
+func SARIFEnabled() bool {
+	return viper.GetString("output.sarif.path") != "" ||
+		viper.GetString("output.sarif.s3_uri") != ""
+}
+
+// SARIFLog is the root of a SARIF document.
+type SARIFLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []SARIFRun `json:"runs"`
+}
+
+// SARIFRun holds the results of a single run of a tool.
+type SARIFRun struct {
+	Tool    SARIFTool     `json:"tool"`
+	Results []SARIFResult `json:"results"`
+}
+
+// SARIFTool describes the tool that produced the results.
+type SARIFTool struct {
+	Driver SARIFDriver `json:"driver"`
+}
+
+// SARIFDriver describes the tool along with the rules its results refer to.
+type SARIFDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []SARIFRule `json:"rules"`
+}
+
+// SARIFRule describes a single vulnerability.
+type SARIFRule struct {
+	ID               string            `json:"id"`
+	ShortDescription SARIFMessage      `json:"shortDescription"`
+	FullDescription  *SARIFMessage     `json:"fullDescription,omitempty"`
+	HelpURI          string            `json:"helpUri,omitempty"`
+	Properties       map[string]string `json:"properties,omitempty"`
+}
+
+// SARIFResult is a single occurrence of a vulnerability in an image.
+type SARIFResult struct {
+	RuleID     string            `json:"ruleId"`
+	Level      string            `json:"level"`
+	Message    SARIFMessage      `json:"message"`
+	Locations  []SARIFLocation   `json:"locations"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// SARIFMessage is a plain text message.
+type SARIFMessage struct {
+	Text string `json:"text"`
+}
+
+// SARIFLocation is the location of a result, which for us is always an image.
+type SARIFLocation struct {
+	PhysicalLocation SARIFPhysicalLocation `json:"physicalLocation"`
+}
+
+// SARIFPhysicalLocation refers to the artifact a result was found in.
+type SARIFPhysicalLocation struct {
+	ArtifactLocation SARIFArtifactLocation `json:"artifactLocation"`
+}
+
+// SARIFArtifactLocation identifies an artifact by URI.
+type SARIFArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// SARIFLevel maps a finding severity onto a SARIF result level.
+func SARIFLevel(severity types.FindingSeverity) string {
+	switch severity {
+	case types.FindingSeverityCritical, types.FindingSeverityHigh:
+		return "error"
+	case types.FindingSeverityMedium:
+		return "warning"
+	default:
+		return "note"
+	}
+}
+
+// FindingAttribute returns the value of the attribute with the given key of a
+// finding, or an empty string if it doesn't have one.
+func FindingAttribute(finding types.ImageScanFinding, key string) string {
+	for _, attribute := range finding.Attributes {
+		if aws.ToString(attribute.Key) == key {
+			return aws.ToString(attribute.Value)
+		}
+	}
+	return ""
+}
+
+// SARIFReport accumulates the findings collected throughout a run so they can
+// be rendered as a single SARIF document. It's a notifier so that it can be
+// handed the findings along with every other notification consumer.
+type SARIFReport struct {
+	mutex   sync.Mutex
+	rules   map[string]SARIFRule
+	results []SARIFResult
+}
+
+// NewSARIFReport creates a new, empty report.
+func NewSARIFReport() *SARIFReport {
+	return &SARIFReport{rules: map[string]SARIFRule{}}
+}
+
+// Notify adds the findings of the notification to the report.
+func (r *SARIFReport) Notify(ctx context.Context, notification ScanNotification) {
+	if notification.Event != EventFindingsCollected {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, finding := range notification.Details {
+		id := aws.ToString(finding.Name)
+		if _, ok := r.rules[id]; !ok {
+			rule := SARIFRule{
+				ID:               id,
+				ShortDescription: SARIFMessage{Text: id},
+				HelpURI:          aws.ToString(finding.Uri),
+				Properties: map[string]string{
+					"severity": string(finding.Severity),
+				},
+			}
+			if finding.Description != nil {
+				rule.FullDescription = &SARIFMessage{Text: *finding.Description}
+			}
+			r.rules[id] = rule
+		}
+
+		pkg := FindingAttribute(finding, "package_name")
+		version := FindingAttribute(finding, "package_version")
+		r.results = append(r.results, SARIFResult{
+			RuleID: id,
+			Level:  SARIFLevel(finding.Severity),
+			Message: SARIFMessage{Text: fmt.Sprintf(
+				"%s in %s %s of %s@%s",
+				id,
+				pkg,
+				version,
+				notification.Repository,
+				notification.ImageDigest,
+			)},
+			Locations: []SARIFLocation{{
+				PhysicalLocation: SARIFPhysicalLocation{
+					ArtifactLocation: SARIFArtifactLocation{
+						URI: notification.Repository + "@" + notification.ImageDigest,
+					},
+				},
+			}},
+			Properties: map[string]string{
+				"imageTag":       notification.ImageTag,
+				"packageName":    pkg,
+				"packageVersion": version,
+				"region":         notification.Region,
+				"severity":       string(finding.Severity),
+			},
+		})
+	}
+}
+
+// Render renders the report as a SARIF document. Rules and results are sorted
+// so that the same findings always render the same document.
+func (r *SARIFReport) Render() ([]byte, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	rules := make([]SARIFRule, 0, len(r.rules))
+	for _, rule := range r.rules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].ID < rules[j].ID
+	})
+
+	results := make([]SARIFResult, len(r.results))
+	copy(results, r.results)
+	sort.SliceStable(results, func(i, j int) bool {
+		a := results[i].Locations[0].PhysicalLocation.ArtifactLocation.URI
+		b := results[j].Locations[0].PhysicalLocation.ArtifactLocation.URI
+		if a != b {
+			return a < b
+		}
+		return results[i].RuleID < results[j].RuleID
+	})
+
+	return json.MarshalIndent(SARIFLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs: []SARIFRun{{
+			Tool: SARIFTool{Driver: SARIFDriver{
+				Name:           "aws-ecr-scan-operator",
+				InformationURI: "https://github.com/celestialorb/aws-ecr-scan-operator",
+				Rules:          rules,
+			}},
+			Results: results,
+		}},
+	}, "", "  ")
+}
+
+// WriteSARIF renders the report and writes it to the configured local file
+// and/or S3 object.
+func WriteSARIF(ctx context.Context, report *SARIFReport) error {
+	document, err := report.Render()
+	if err != nil {
+		return err
+	}
+
+	if path := viper.GetString("output.sarif.path"); path != "" {
+		if err := os.WriteFile(path, document, 0o644); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"path": path,
+		}).Info("wrote SARIF findings report")
+	}
+
+	if uri := viper.GetString("output.sarif.s3_uri"); uri != "" {
+		bucket, key, err := ParseS3URI(uri)
+		if err != nil {
+			return err
+		}
+
+		cfg, err := LoadAWSConfig(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = s3.NewFromConfig(cfg).PutObject(ctx, &s3.PutObjectInput{
+			Body:        bytes.NewReader(document),
+			Bucket:      aws.String(bucket),
+			ContentType: aws.String("application/sarif+json"),
+			Key:         aws.String(key),
+		})
+		if err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"uri": uri,
+		}).Info("uploaded SARIF findings report")
+	}
+	return nil
+}
+
+// ParseS3URI splits an `s3://bucket/key` URI into its bucket and key.
+func ParseS3URI(uri string) (string, string, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return "", "", err
+	}
+
+	key := strings.TrimPrefix(parsed.Path, "/")
+	if parsed.Scheme != "s3" || parsed.Host == "" || key == "" {
+		return "", "", fmt.Errorf("invalid S3 URI %q, expected s3://bucket/key", uri)
+	}
+	return parsed.Host, key, nil
+}
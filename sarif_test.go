@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// sampleFinding returns a finding of the vulnerability in a package.
+func sampleFinding(name string, severity types.FindingSeverity, pkg string, version string) types.ImageScanFinding {
+	return types.ImageScanFinding{
+		Name:        aws.String(name),
+		Description: aws.String("A vulnerability in " + pkg + "."),
+		Severity:    severity,
+		Uri:         aws.String("https://security-tracker.debian.org/tracker/" + name),
+		Attributes: []types.Attribute{
+			{Key: aws.String("package_version"), Value: aws.String(version)},
+			{Key: aws.String("package_name"), Value: aws.String(pkg)},
+		},
+	}
+}
+
+func TestSARIFLevel(t *testing.T) {
+	tests := map[types.FindingSeverity]string{
+		types.FindingSeverityCritical:      "error",
+		types.FindingSeverityHigh:          "error",
+		types.FindingSeverityMedium:        "warning",
+		types.FindingSeverityLow:           "note",
+		types.FindingSeverityInformational: "note",
+		types.FindingSeverityUndefined:     "note",
+	}
+	for severity, want := range tests {
+		if got := SARIFLevel(severity); got != want {
+			t.Errorf("SARIFLevel(%s) = %s, want %s", severity, got, want)
+		}
+	}
+}
+
+func TestSARIFReportRender(t *testing.T) {
+	report := NewSARIFReport()
+	report.Notify(context.Background(), ScanNotification{
+		Event:       EventFindingsCollected,
+		Region:      "us-east-1",
+		Repository:  "web",
+		ImageDigest: "sha256:bbb",
+		ImageTag:    "v2",
+		Details: []types.ImageScanFinding{
+			sampleFinding("CVE-2022-0002", types.FindingSeverityMedium, "openssl", "1.1.1n-0"),
+			sampleFinding("CVE-2022-0001", types.FindingSeverityCritical, "zlib", "1:1.2.11"),
+		},
+	})
+	report.Notify(context.Background(), ScanNotification{
+		Event:       EventFindingsCollected,
+		Region:      "us-east-1",
+		Repository:  "api",
+		ImageDigest: "sha256:aaa",
+		ImageTag:    "latest",
+		Details: []types.ImageScanFinding{
+			sampleFinding("CVE-2022-0001", types.FindingSeverityCritical, "zlib", "1:1.2.11"),
+			sampleFinding("CVE-2022-0003", types.FindingSeverityLow, "bash", "5.1-2"),
+		},
+	})
+	// Only collected findings make it into the report.
+	report.Notify(context.Background(), ScanNotification{
+		Event:       EventScanRequested,
+		Repository:  "worker",
+		ImageDigest: "sha256:ccc",
+		Details:     []types.ImageScanFinding{sampleFinding("CVE-2022-0004", types.FindingSeverityHigh, "curl", "7.74.0")},
+	})
+
+	document, err := report.Render()
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	golden(t, "findings.sarif", document)
+}
+
+func TestSARIFReportRenderEmpty(t *testing.T) {
+	document, err := NewSARIFReport().Render()
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	golden(t, "empty.sarif", document)
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// DesiredScanningRule is a rule of the desired registry scanning configuration,
+// scanning the repositories matching any of its wildcard filters at the given
+// frequency.
+type DesiredScanningRule struct {
+	ScanFrequency string   `mapstructure:"scan_frequency"`
+	Filters       []string `mapstructure:"filters"`
+}
+
+// FetchScanningConfiguration retrieves the registry-wide image scanning
+// configuration for the given registry.
+func FetchScanningConfiguration(
+	ctx context.Context,
+	registry Registry,
+) (*types.RegistryScanningConfiguration, error) {
+	response, err := registry.Client.GetRegistryScanningConfiguration(
+		ctx,
+		&ecr.GetRegistryScanningConfigurationInput{},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return response.ScanningConfiguration, nil
+}
+
+// DesiredScanningConfiguration returns the request converging a registry to
+// the desired scanning configuration, validating the scan type and rules.
+func DesiredScanningConfiguration() (*ecr.PutRegistryScanningConfigurationInput, error) {
+	input := &ecr.PutRegistryScanningConfigurationInput{
+		ScanType: types.ScanType(strings.ToUpper(viper.GetString("scan.desired_configuration.scan_type"))),
+	}
+	switch input.ScanType {
+	case types.ScanTypeBasic, types.ScanTypeEnhanced:
+	default:
+		return nil, fmt.Errorf("unknown scan type %q, expected BASIC or ENHANCED", input.ScanType)
+	}
+
+	var rules []DesiredScanningRule
+	if err := viper.UnmarshalKey("scan.desired_configuration.rules", &rules); err != nil {
+		return nil, err
+	}
+	for i, rule := range rules {
+		frequency := types.ScanFrequency(strings.ToUpper(rule.ScanFrequency))
+		switch frequency {
+		case types.ScanFrequencyScanOnPush, types.ScanFrequencyContinuousScan, types.ScanFrequencyManual:
+		default:
+			return nil, fmt.Errorf(
+				"unknown scan frequency %q of rule %d, expected SCAN_ON_PUSH, CONTINUOUS_SCAN or MANUAL",
+				rule.ScanFrequency,
+				i,
+			)
+		}
+		if len(rule.Filters) == 0 {
+			return nil, fmt.Errorf("rule %d has no repository filters", i)
+		}
+
+		converted := types.RegistryScanningRule{ScanFrequency: frequency}
+		for _, filter := range rule.Filters {
+			filter := filter
+			converted.RepositoryFilters = append(converted.RepositoryFilters, types.ScanningRepositoryFilter{
+				Filter:     &filter,
+				FilterType: types.ScanningRepositoryFilterTypeWildcard,
+			})
+		}
+		input.Rules = append(input.Rules, converted)
+	}
+	return input, nil
+}
+
+// ScanningConfigurationMatches reports whether the registry's scanning
+// configuration already is the desired one. Rules must be in the same order,
+// while the filters of each rule may be in any order.
+func ScanningConfigurationMatches(
+	current *types.RegistryScanningConfiguration,
+	desired *ecr.PutRegistryScanningConfigurationInput,
+) bool {
+	if current == nil || current.ScanType != desired.ScanType || len(current.Rules) != len(desired.Rules) {
+		return false
+	}
+
+	filters := func(rule types.RegistryScanningRule) string {
+		var all []string
+		for _, filter := range rule.RepositoryFilters {
+			if filter.Filter != nil {
+				all = append(all, string(filter.FilterType)+":"+*filter.Filter)
+			}
+		}
+		sort.Strings(all)
+		return strings.Join(all, ",")
+	}
+	for i := range current.Rules {
+		if current.Rules[i].ScanFrequency != desired.Rules[i].ScanFrequency ||
+			filters(current.Rules[i]) != filters(desired.Rules[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ConvergeScanningConfiguration updates the registry's scanning configuration
+// to the desired one unless it already matches, returning the resulting
+// configuration. In dry-run mode the update is only logged.
+func ConvergeScanningConfiguration(
+	ctx context.Context,
+	registry Registry,
+	current *types.RegistryScanningConfiguration,
+) (*types.RegistryScanningConfiguration, error) {
+	desired, err := DesiredScanningConfiguration()
+	if err != nil {
+		return current, err
+	}
+	if ScanningConfigurationMatches(current, desired) {
+		return current, nil
+	}
+
+	logger := registry.Logger.WithFields(log.Fields{
+		"region":   registry.Region,
+		"rules":    len(desired.Rules),
+		"scanType": desired.ScanType,
+	})
+	if viper.GetBool("scan.dry_run") {
+		logger.Info("dry-run, skipping registry scanning configuration update")
+		return current, nil
+	}
+
+	logger.Info("updating registry scanning configuration")
+	response, err := registry.Client.PutRegistryScanningConfiguration(ctx, desired)
+	if err != nil {
+		return current, err
+	}
+	registry.Metrics.ScanningConfigurationUpdates.WithLabelValues(registry.Region).Inc()
+	return response.RegistryScanningConfiguration, nil
+}
+
+// IsScanOnPush reports whether the repository scans every image pushed to it
+// by itself. Repositories given by name rather than described never do, as
+// their configuration isn't known.
+func IsScanOnPush(repository types.Repository) bool {
+	return repository.ImageScanningConfiguration != nil &&
+		repository.ImageScanningConfiguration.ScanOnPush
+}
+
+// ContinuousScanFilters are the repository filters of the continuous scanning
+// rules of a registry scanning configuration, compiled once for every
+// repository of the run.
+type ContinuousScanFilters []*regexp.Regexp
+
+// NewContinuousScanFilters compiles the repository filters of the continuous
+// scanning rules of the configuration, if it has any.
+func NewContinuousScanFilters(configuration *types.RegistryScanningConfiguration) ContinuousScanFilters {
+	if configuration == nil {
+		return nil
+	}
+
+	var filters ContinuousScanFilters
+	for _, rule := range configuration.Rules {
+		if rule.ScanFrequency != types.ScanFrequencyContinuousScan {
+			continue
+		}
+		for _, filter := range rule.RepositoryFilters {
+			if filter.Filter != nil {
+				filters = append(filters, WildcardExpression(*filter.Filter))
+			}
+		}
+	}
+	return filters
+}
+
+// Covers reports whether the repository with the given name is covered by a
+// continuous scanning rule, in which case manually requesting scans is
+// pointless.
+func (f ContinuousScanFilters) Covers(name string) bool {
+	for _, filter := range f {
+		if filter.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// WildcardExpression returns the regular expression of an AWS ECR wildcard
+// filter, where `*` matches any sequence of characters including `/`.
+func WildcardExpression(filter string) *regexp.Regexp {
+	return regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(filter), `\*`, ".*") + "$")
+}
+
+// SkipRecentlyScanned removes any images that completed a scan within the given
+// interval according to their details, as requesting another scan would only be
+// rate-limited.
+func SkipRecentlyScanned(
+	registry Registry,
+	repository types.Repository,
+	images []types.ImageIdentifier,
+	details map[string]types.ImageDetail,
+	interval time.Duration,
+) []types.ImageIdentifier {
+	// Setup our logging context for the function.
+	logger := registry.Logger.WithFields(log.Fields{
+		"region":     registry.Region,
+		"repository": *repository.RepositoryName,
+	})
+
+	var remaining []types.ImageIdentifier
+	cutoff := time.Now().Add(-interval)
+	for _, image := range images {
+		if scanned, ok := LastScanTime(details[*image.ImageDigest]); ok && scanned.After(cutoff) {
+			logger.WithFields(log.Fields{
+				"image": map[string]string{
+					"digest": *image.ImageDigest,
+					"tag":    ImageTag(image),
+				},
+				"scanned": scanned,
+			}).Debug("image scanned recently, skipping")
+			registry.Metrics.ScansSkippedRecent.WithLabelValues(registry.Region).Inc()
+			continue
+		}
+		remaining = append(remaining, image)
+	}
+	return remaining
+}
+
+// ScanStatusNone stands for the scan status of an image that has never been
+// scanned, which AWS ECR has no status of its own for.
+const ScanStatusNone = "NONE"
+
+// ParseScanStatuses parses the given list of scan statuses case-insensitively,
+// which may include ScanStatusNone.
+func ParseScanStatuses(values []string) (map[string]bool, error) {
+	known := map[string]bool{ScanStatusNone: true}
+	for _, status := range types.ScanStatus("").Values() {
+		known[string(status)] = true
+	}
+
+	statuses := map[string]bool{}
+	for _, value := range values {
+		status := strings.ToUpper(value)
+		if !known[status] {
+			return nil, fmt.Errorf("unknown scan status %q", value)
+		}
+		statuses[status] = true
+	}
+	return statuses, nil
+}
+
+// ScanStatus returns the status of the most recent scan in the given image
+// details, or ScanStatusNone if the image has never been scanned.
+func ScanStatus(detail types.ImageDetail) string {
+	if detail.ImageScanStatus == nil || detail.ImageScanStatus.Status == "" {
+		return ScanStatusNone
+	}
+	return string(detail.ImageScanStatus.Status)
+}
+
+// FilterScanStatus returns the images whose previous scan status is included,
+// every status being included if none are, and isn't excluded. Exclusions take
+// precedence over inclusions, and images without details are kept.
+func FilterScanStatus(
+	registry Registry,
+	repository types.Repository,
+	images []types.ImageIdentifier,
+	details map[string]types.ImageDetail,
+	include map[string]bool,
+	exclude map[string]bool,
+) []types.ImageIdentifier {
+	// Setup our logging context for the function.
+	logger := registry.Logger.WithFields(log.Fields{
+		"region":     registry.Region,
+		"repository": *repository.RepositoryName,
+	})
+
+	var remaining []types.ImageIdentifier
+	for _, image := range images {
+		detail, ok := details[*image.ImageDigest]
+		if !ok {
+			remaining = append(remaining, image)
+			continue
+		}
+		status := ScanStatus(detail)
+		if exclude[status] || (len(include) > 0 && !include[status]) {
+			logger.WithFields(log.Fields{
+				"image": map[string]string{
+					"digest": *image.ImageDigest,
+					"tag":    ImageTag(image),
+				},
+				"status": status,
+			}).Debug("image scan status filtered out, skipping")
+			registry.Metrics.ImagesSkippedScanStatus.WithLabelValues(registry.Region).Inc()
+			continue
+		}
+		remaining = append(remaining, image)
+	}
+	return remaining
+}
+
+// LastScanTime returns the completion time of the most recent scan in the given
+// image details, if the image has been scanned at all.
+func LastScanTime(detail types.ImageDetail) (time.Time, bool) {
+	if detail.ImageScanFindingsSummary == nil ||
+		detail.ImageScanFindingsSummary.ImageScanCompletedAt == nil {
+		return time.Time{}, false
+	}
+	return *detail.ImageScanFindingsSummary.ImageScanCompletedAt, true
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+func TestContinuousScanFilters(t *testing.T) {
+	filters := NewContinuousScanFilters(&types.RegistryScanningConfiguration{
+		Rules: []types.RegistryScanningRule{
+			{
+				ScanFrequency: types.ScanFrequencyContinuousScan,
+				RepositoryFilters: []types.ScanningRepositoryFilter{
+					{Filter: aws.String("team-a/*")},
+					{Filter: aws.String("web.api")},
+				},
+			},
+			{
+				ScanFrequency:     types.ScanFrequencyScanOnPush,
+				RepositoryFilters: []types.ScanningRepositoryFilter{{Filter: aws.String("*")}},
+			},
+		},
+	})
+	tests := map[string]bool{
+		"team-a/api":        true,
+		"team-a/nested/api": true,
+		"team-b/api":        false,
+		"web.api":           true,
+		"webxapi":           false,
+	}
+	for name, want := range tests {
+		if got := filters.Covers(name); got != want {
+			t.Errorf("Covers(%q) = %t, want %t", name, got, want)
+		}
+	}
+
+	if NewContinuousScanFilters(nil).Covers("team-a/api") {
+		t.Error("Covers() = true without a scanning configuration, want false")
+	}
+}
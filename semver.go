@@ -0,0 +1,218 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+const (
+	// SemverOtherScan scans the images whose tags aren't semantic versions
+	// alongside the latest of each series.
+	SemverOtherScan = "scan"
+
+	// SemverOtherSkip skips the images whose tags aren't semantic versions.
+	SemverOtherSkip = "skip"
+)
+
+// Semver is a semantic version parsed from an image tag.
+type Semver struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease []string
+}
+
+// ParseSemver parses the tag as a semantic version, optionally prefixed by a
+// "v" as is common for tags. Build metadata is ignored, as it doesn't take
+// part in precedence.
+func ParseSemver(tag string) (Semver, bool) {
+	tag = strings.TrimPrefix(tag, "v")
+	if i := strings.IndexByte(tag, '+'); i >= 0 {
+		if !validIdentifiers(tag[i+1:]) {
+			return Semver{}, false
+		}
+		tag = tag[:i]
+	}
+	var version Semver
+	if i := strings.IndexByte(tag, '-'); i >= 0 {
+		if !validIdentifiers(tag[i+1:]) {
+			return Semver{}, false
+		}
+		version.Prerelease = strings.Split(tag[i+1:], ".")
+		for _, identifier := range version.Prerelease {
+			if numeric(identifier) && len(identifier) > 1 && identifier[0] == '0' {
+				return Semver{}, false
+			}
+		}
+		tag = tag[:i]
+	}
+
+	parts := strings.Split(tag, ".")
+	if len(parts) != 3 {
+		return Semver{}, false
+	}
+	numbers := make([]uint64, len(parts))
+	for i, part := range parts {
+		if !numeric(part) || (len(part) > 1 && part[0] == '0') {
+			return Semver{}, false
+		}
+		number, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return Semver{}, false
+		}
+		numbers[i] = number
+	}
+	version.Major, version.Minor, version.Patch = numbers[0], numbers[1], numbers[2]
+	return version, true
+}
+
+// Series returns the major.minor series the version belongs to.
+func (v Semver) Series() string {
+	return strconv.FormatUint(v.Major, 10) + "." + strconv.FormatUint(v.Minor, 10)
+}
+
+// Compare returns -1, 0 or 1 as the version precedes, equals or follows the
+// other by semantic version precedence, where a prerelease precedes the
+// release of the same version.
+func (v Semver) Compare(other Semver) int {
+	for _, pair := range [][2]uint64{{v.Major, other.Major}, {v.Minor, other.Minor}, {v.Patch, other.Patch}} {
+		if pair[0] != pair[1] {
+			if pair[0] < pair[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case len(v.Prerelease) == 0 && len(other.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(other.Prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(other.Prerelease); i++ {
+		if c := compareIdentifier(v.Prerelease[i], other.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(v.Prerelease) < len(other.Prerelease):
+		return -1
+	case len(v.Prerelease) > len(other.Prerelease):
+		return 1
+	}
+	return 0
+}
+
+// compareIdentifier compares prerelease identifiers, numeric ones numerically
+// and before any alphanumeric ones, which are compared lexically.
+func compareIdentifier(a, b string) int {
+	switch an, bn := numeric(a), numeric(b); {
+	case an && bn:
+		if len(a) != len(b) {
+			if len(a) < len(b) {
+				return -1
+			}
+			return 1
+		}
+	case an:
+		return -1
+	case bn:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// numeric reports whether the string is made up of digits only.
+func numeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// validIdentifiers reports whether the string is a dot-separated list of
+// non-empty identifiers made up of alphanumerics and hyphens.
+func validIdentifiers(s string) bool {
+	for _, identifier := range strings.Split(s, ".") {
+		if identifier == "" {
+			return false
+		}
+		for _, c := range identifier {
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// SelectLatestSemver returns the images tagged with the latest version of each
+// major.minor series, along with the images whose tags aren't semantic
+// versions, untagged ones included, unless they're to be skipped. An image is
+// kept if any of its tags is, as the scan applies to its digest regardless.
+func SelectLatestSemver(
+	registry Registry,
+	repository types.Repository,
+	images []types.ImageIdentifier,
+	other string,
+) []types.ImageIdentifier {
+	// Setup our logging context for the function.
+	logger := registry.Logger.WithFields(log.Fields{
+		"region":     registry.Region,
+		"repository": *repository.RepositoryName,
+	})
+
+	// Find the latest version of each series first.
+	versions := make([]*Semver, len(images))
+	latest := map[string]Semver{}
+	for i, image := range images {
+		if image.ImageTag == nil {
+			continue
+		}
+		version, ok := ParseSemver(*image.ImageTag)
+		if !ok {
+			continue
+		}
+		versions[i] = &version
+		if current, ok := latest[version.Series()]; !ok || version.Compare(current) > 0 {
+			latest[version.Series()] = version
+		}
+	}
+
+	kept := map[string]bool{}
+	for i, image := range images {
+		switch {
+		case versions[i] == nil && other == SemverOtherSkip:
+		case versions[i] == nil || versions[i].Compare(latest[versions[i].Series()]) == 0:
+			kept[*image.ImageDigest] = true
+		}
+	}
+
+	var selected []types.ImageIdentifier
+	for _, image := range images {
+		if kept[*image.ImageDigest] {
+			selected = append(selected, image)
+			continue
+		}
+		registry.Metrics.ImagesSkippedSemver.WithLabelValues(registry.Region).Inc()
+		logger.WithFields(log.Fields{
+			"image": map[string]string{
+				"digest": *image.ImageDigest,
+				"tag":    ImageTag(image),
+			},
+		}).Debug("image isn't the latest of its semantic version series, skipping")
+	}
+	return selected
+}
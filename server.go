@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// NewServer creates our webserver, serving our metrics along with the health
+// and readiness of the operator, and triggering runs on demand. Every handler
+// is registered on the server's own mux, beneath the configured base path.
+func NewServer(gatherer prometheus.Gatherer, health *Health, runner *Runner) *http.Server {
+	base := strings.TrimSuffix(viper.GetString("web.base_path"), "/")
+	mux := http.NewServeMux()
+
+	// Add our Prometheus metrics handler.
+	log.Debug("adding Prometheus metrics handler")
+	mux.Handle(base+viper.GetString("metrics.path"), promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+
+	// Add our health and readiness handlers for orchestration probes.
+	log.Debug("adding health and readiness handlers")
+	mux.HandleFunc(base+viper.GetString("web.health_path"), health.ServeHealth)
+	mux.HandleFunc(base+viper.GetString("web.ready_path"), health.ServeReady)
+
+	// Add our handler for triggering runs outside of the schedule, if we have
+	// runs to trigger at all.
+	if runner != nil {
+		log.Debug("adding trigger handler")
+		mux.HandleFunc(base+viper.GetString("web.trigger_path"), runner.ServeTrigger)
+	}
+
+	// Add our profiling handlers, only when asked for as they expose the
+	// internals of the operator to anyone who can reach the webserver.
+	// The handlers expect to be at the root, so our base path is stripped.
+	if viper.GetBool("debug.pprof.enabled") {
+		log.Warn("adding pprof profiling handlers")
+		profiling := map[string]http.HandlerFunc{
+			"/debug/pprof/":        pprof.Index,
+			"/debug/pprof/cmdline": pprof.Cmdline,
+			"/debug/pprof/profile": pprof.Profile,
+			"/debug/pprof/symbol":  pprof.Symbol,
+			"/debug/pprof/trace":   pprof.Trace,
+		}
+		for path, handler := range profiling {
+			mux.Handle(base+path, http.StripPrefix(base, handler))
+		}
+	}
+
+	// Require a bearer token on everything but our probes, which orchestration
+	// can't authenticate, if one is configured.
+	var handler http.Handler = mux
+	if token := viper.GetString("web.auth.bearer_token"); token != "" {
+		log.Debug("requiring bearer token on webserver")
+		handler = RequireBearerToken(mux, token,
+			base+viper.GetString("web.health_path"),
+			base+viper.GetString("web.ready_path"),
+		)
+	}
+
+	// Bound every phase of each connection so that slow or idle clients can't
+	// tie up the webserver.
+	return &http.Server{
+		Addr: fmt.Sprintf(
+			"%s:%d",
+			viper.GetString("web.host"),
+			viper.GetInt32("web.port"),
+		),
+		Handler:           handler,
+		IdleTimeout:       viper.GetDuration("web.idle_timeout"),
+		ReadHeaderTimeout: viper.GetDuration("web.read_timeout"),
+		ReadTimeout:       viper.GetDuration("web.read_timeout"),
+		WriteTimeout:      viper.GetDuration("web.write_timeout"),
+	}
+}
+
+// RequireBearerToken wraps the handler, rejecting requests to any but the
+// exempt paths unless they carry the token in their Authorization header.
+func RequireBearerToken(next http.Handler, token string, exempt ...string) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for _, path := range exempt {
+			if req.URL.Path == path {
+				next.ServeHTTP(w, req)
+				return
+			}
+		}
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// ListenAndServe serves the webserver over HTTPS if a certificate and key are
+// configured, or over plain HTTP otherwise.
+func ListenAndServe(server *http.Server) error {
+	cert := viper.GetString("web.tls.cert")
+	key := viper.GetString("web.tls.key")
+	if cert != "" || key != "" {
+		return server.ListenAndServeTLS(cert, key)
+	}
+	return server.ListenAndServe()
+}
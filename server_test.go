@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestNewServerPprof(t *testing.T) {
+	paths := []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/pprof/symbol"}
+	tests := []struct {
+		name    string
+		enabled bool
+		base    string
+		status  int
+	}{
+		{name: "enabled", enabled: true, status: http.StatusOK},
+		{name: "enabled beneath a base path", enabled: true, base: "/operator", status: http.StatusOK},
+		{name: "disabled", enabled: false, status: http.StatusNotFound},
+		{name: "disabled beneath a base path", enabled: false, base: "/operator", status: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			configure(t, map[string]interface{}{
+				"debug.pprof.enabled": test.enabled,
+				"web.base_path":       test.base,
+			})
+			server := NewServer(prometheus.NewRegistry(), &Health{}, nil)
+			for _, path := range paths {
+				recorder := httptest.NewRecorder()
+				server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.base+path, nil))
+				if recorder.Code != test.status {
+					t.Errorf("GET %s = %d, want %d", test.base+path, recorder.Code, test.status)
+				}
+			}
+
+			// Our own handlers are served either way.
+			recorder := httptest.NewRecorder()
+			server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.base+"/metrics", nil))
+			if recorder.Code != http.StatusOK {
+				t.Errorf("GET %s/metrics = %d, want %d", test.base, recorder.Code, http.StatusOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SlackMessage is the payload of a Slack incoming webhook.
+type SlackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []SlackAttachment `json:"attachments,omitempty"`
+}
+
+// SlackAttachment is a block of fields within a Slack message, highlighted in
+// the given color.
+type SlackAttachment struct {
+	Color  string       `json:"color"`
+	Fields []SlackField `json:"fields"`
+}
+
+// SlackField is a single titled value of a Slack attachment.
+type SlackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// NewSlackRunMessage builds the Slack message summarizing a finished run. The
+// message is colored by how well the run went, so problems stand out.
+func NewSlackRunMessage(result RunResult, duration time.Duration) SlackMessage {
+	color := "good"
+	text := "AWS ECR scan run finished"
+	switch {
+	case !result.Succeeded():
+		color = "danger"
+		text = "AWS ECR scan run failed"
+	case result.Failures > 0 || result.OverThreshold > 0:
+		color = "warning"
+		text = "AWS ECR scan run finished with issues"
+	}
+
+	field := func(title string, value int64) SlackField {
+		return SlackField{Title: title, Value: strconv.FormatInt(value, 10), Short: true}
+	}
+	fields := []SlackField{
+		field("Repositories", result.Repositories),
+		field("Images", result.Images),
+		field("Scans Requested", result.Requested),
+		field("Rate-Limited", result.RateLimited),
+		field("Skipped", result.Skipped),
+		field("Unsupported", result.Unsupported),
+		field("Errors", result.Failures),
+	}
+	if result.OverThreshold > 0 {
+		fields = append(fields, field("Images Over Threshold", result.OverThreshold))
+	}
+	fields = append(fields, SlackField{
+		Title: "Duration",
+		Value: duration.Round(time.Second).String(),
+		Short: true,
+	})
+
+	return SlackMessage{
+		Text:        text,
+		Attachments: []SlackAttachment{{Color: color, Fields: fields}},
+	}
+}
+
+// SlackNotifier posts a summary of each run to a Slack incoming webhook. At
+// most one summary is posted per interval, so frequent runs don't flood the
+// channel.
+type SlackNotifier struct {
+	Client     *http.Client
+	Metrics    *Metrics
+	WebhookURL string
+	Interval   time.Duration
+
+	mutex  sync.Mutex
+	posted time.Time
+}
+
+// NewSlackNotifier creates a notifier posting to the given webhook at most
+// once per interval.
+func NewSlackNotifier(metrics *Metrics, url string, interval time.Duration) *SlackNotifier {
+	return &SlackNotifier{
+		Client:     &http.Client{Timeout: 10 * time.Second},
+		Metrics:    metrics,
+		WebhookURL: url,
+		Interval:   interval,
+	}
+}
+
+// NotifyRun posts the summary of a run, unless a summary was posted too
+// recently. The summaries of runs with failures are always posted, and only a
+// summary that was actually posted holds off the next.
+func (n *SlackNotifier) NotifyRun(ctx context.Context, result RunResult, duration time.Duration) {
+	failed := !result.Succeeded() || result.Failures > 0
+	n.mutex.Lock()
+	recent := !n.posted.IsZero() && time.Since(n.posted) < n.Interval
+	n.mutex.Unlock()
+	if recent && !failed {
+		log.WithFields(log.Fields{
+			"interval": n.Interval,
+		}).Debug("posted to Slack recently, skipping run summary")
+		return
+	}
+
+	err := n.post(ctx, NewSlackRunMessage(result, duration))
+	n.Metrics.RecordDelivery(ChannelSlack, err)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to post run summary to Slack")
+		return
+	}
+	n.mutex.Lock()
+	n.posted = time.Now()
+	n.mutex.Unlock()
+}
+
+// post delivers a message to the webhook.
+func (n *SlackNotifier) post(ctx context.Context, message SlackMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, n.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := n.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status %q", response.Status)
+	}
+	return nil
+}
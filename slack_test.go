@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewSlackRunMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		result RunResult
+		color  string
+		text   string
+		titles []string
+	}{
+		{
+			name:   "succeeded",
+			result: RunResult{Reconciled: 1, Repositories: 2, Images: 10, Requested: 4},
+			color:  "good",
+			text:   "AWS ECR scan run finished",
+		},
+		{
+			name:   "failed",
+			result: RunResult{},
+			color:  "danger",
+			text:   "AWS ECR scan run failed",
+		},
+		{
+			name:   "over threshold",
+			result: RunResult{Reconciled: 1, OverThreshold: 2},
+			color:  "warning",
+			text:   "AWS ECR scan run finished with issues",
+			titles: []string{"Images Over Threshold"},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			message := NewSlackRunMessage(test.result, 90*time.Second)
+			if message.Text != test.text || len(message.Attachments) != 1 || message.Attachments[0].Color != test.color {
+				t.Fatalf("NewSlackRunMessage() = %+v, want %q colored %q", message, test.text, test.color)
+			}
+			var titles []string
+			for _, field := range message.Attachments[0].Fields {
+				titles = append(titles, field.Title)
+			}
+			want := append([]string{"Repositories", "Images", "Scans Requested", "Rate-Limited", "Skipped", "Unsupported", "Errors"}, test.titles...)
+			want = append(want, "Duration")
+			if !reflect.DeepEqual(titles, want) {
+				t.Errorf("NewSlackRunMessage() fields = %v, want %v", titles, want)
+			}
+		})
+	}
+}
+
+func TestSlackNotifierNotifyRun(t *testing.T) {
+	var message SlackMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected %s request with headers %v", r.Method, r.Header)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			t.Errorf("failed to decode message: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	notifier := NewSlackNotifier(NewMetrics(prometheus.NewRegistry()), server.URL, time.Hour)
+	notifier.NotifyRun(context.Background(), RunResult{Reconciled: 1, Repositories: 3, Requested: 7}, 65*time.Second)
+	// A second run within the interval isn't posted.
+	notifier.NotifyRun(context.Background(), RunResult{Reconciled: 1}, time.Second)
+
+	if message.Text != "AWS ECR scan run finished" || len(message.Attachments) != 1 {
+		t.Fatalf("posted %+v, want the summary of the first run", message)
+	}
+	fields := map[string]string{}
+	for _, field := range message.Attachments[0].Fields {
+		fields[field.Title] = field.Value
+	}
+	if fields["Repositories"] != "3" || fields["Scans Requested"] != "7" || fields["Duration"] != "1m5s" {
+		t.Errorf("posted fields %v, want 3 repositories, 7 scans requested over 1m5s", fields)
+	}
+	if got := testutil.ToFloat64(notifier.Metrics.NotificationsSent.WithLabelValues(ChannelSlack)); got != 1 {
+		t.Errorf("aws_ecr_notifications_sent = %v, want 1", got)
+	}
+}
+
+func TestSlackNotifierPostFails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no_service", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	notifier := NewSlackNotifier(NewMetrics(prometheus.NewRegistry()), server.URL, 0)
+	if err := notifier.post(context.Background(), NewSlackRunMessage(RunResult{}, 0)); err == nil {
+		t.Error("post() error = nil, want an error for the 404 response")
+	}
+	notifier.NotifyRun(context.Background(), RunResult{}, 0)
+	if got := testutil.ToFloat64(notifier.Metrics.NotificationsErrors.WithLabelValues(ChannelSlack)); got != 1 {
+		t.Errorf("aws_ecr_notifications_errors = %v, want 1", got)
+	}
+}
+
+func TestSlackNotifierThrottle(t *testing.T) {
+	var texts []string
+	fail := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			fail = false
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var message SlackMessage
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			t.Errorf("failed to decode message: %v", err)
+		}
+		texts = append(texts, message.Text)
+	}))
+	defer server.Close()
+
+	// A summary that failed to post doesn't hold off the next, while one that
+	// was posted holds off the next successful run but not a failed one.
+	notifier := NewSlackNotifier(NewMetrics(prometheus.NewRegistry()), server.URL, time.Hour)
+	notifier.NotifyRun(context.Background(), RunResult{Reconciled: 1}, 0)
+	notifier.NotifyRun(context.Background(), RunResult{Reconciled: 1}, 0)
+	notifier.NotifyRun(context.Background(), RunResult{Reconciled: 1}, 0)
+	notifier.NotifyRun(context.Background(), RunResult{Reconciled: 1, Failures: 2}, 0)
+	notifier.NotifyRun(context.Background(), RunResult{}, 0)
+
+	want := []string{
+		"AWS ECR scan run finished",
+		"AWS ECR scan run finished with issues",
+		"AWS ECR scan run failed",
+	}
+	if !reflect.DeepEqual(texts, want) {
+		t.Errorf("posted %q, want %q", texts, want)
+	}
+}
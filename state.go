@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+
+	dbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// StateBackendNone keeps no state, relying on AWS ECR alone.
+	StateBackendNone = "none"
+
+	// StateBackendMemory keeps state in memory, which is lost on restart.
+	StateBackendMemory = "memory"
+
+	// StateBackendDynamoDB keeps state in an AWS DynamoDB table.
+	StateBackendDynamoDB = "dynamodb"
+)
+
+// StateStore remembers when a scan of each image was last requested, so that
+// redundant scans can be skipped without asking AWS ECR.
+type StateStore interface {
+	// LastScan returns when a scan of the image was last requested, if ever.
+	LastScan(ctx context.Context, key string) (time.Time, bool, error)
+
+	// RecordScan records that a scan of the image was requested at the time.
+	RecordScan(ctx context.Context, key string, at time.Time) error
+}
+
+// StateKey returns the key of an image in a state store, unique across
+// profiles, regions, registries and repositories.
+func StateKey(registry Registry, repository types.Repository, image types.ImageIdentifier) string {
+	return fmt.Sprintf(
+		"%s/%s/%s@%s",
+		RegistryName(registry),
+		aws.ToString(registry.ID),
+		*repository.RepositoryName,
+		*image.ImageDigest,
+	)
+}
+
+// WatermarkKey returns the key of a repository's watermark in a state store,
+// which never collides with the key of an image.
+func WatermarkKey(registry Registry, repository types.Repository) string {
+	return fmt.Sprintf(
+		"%s/%s/%s#watermark",
+		RegistryName(registry),
+		aws.ToString(registry.ID),
+		*repository.RepositoryName,
+	)
+}
+
+// MemoryStateStore keeps state in memory for the lifetime of the process.
+type MemoryStateStore struct {
+	mutex sync.Mutex
+	scans map[string]time.Time
+}
+
+// NewMemoryStateStore creates an empty in-memory state store.
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{scans: map[string]time.Time{}}
+}
+
+// LastScan returns when a scan of the image was last requested, if ever.
+func (s *MemoryStateStore) LastScan(ctx context.Context, key string) (time.Time, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	at, ok := s.scans[key]
+	return at, ok, nil
+}
+
+// RecordScan records that a scan of the image was requested at the time.
+func (s *MemoryStateStore) RecordScan(ctx context.Context, key string, at time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.scans[key] = at
+	return nil
+}
+
+// DynamoDBStateStore keeps state in an AWS DynamoDB table, which must have a
+// string partition key named `image`. The time a scan was last requested is
+// kept in the `lastScan` attribute, in seconds since the epoch.
+type DynamoDBStateStore struct {
+	Client *dynamodb.Client
+	Table  string
+}
+
+// NewDynamoDBStateStore creates a state store backed by the given table.
+func NewDynamoDBStateStore(cfg aws.Config, table string) *DynamoDBStateStore {
+	return &DynamoDBStateStore{
+		Client: dynamodb.NewFromConfig(cfg),
+		Table:  table,
+	}
+}
+
+// LastScan returns when a scan of the image was last requested, if ever.
+func (s *DynamoDBStateStore) LastScan(ctx context.Context, key string) (time.Time, bool, error) {
+	response, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		Key: map[string]dbtypes.AttributeValue{
+			"image": &dbtypes.AttributeValueMemberS{Value: key},
+		},
+		TableName: aws.String(s.Table),
+	})
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	attribute, ok := response.Item["lastScan"].(*dbtypes.AttributeValueMemberN)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	seconds, err := strconv.ParseInt(attribute.Value, 10, 64)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return time.Unix(seconds, 0), true, nil
+}
+
+// RecordScan records that a scan of the image was requested at the time.
+func (s *DynamoDBStateStore) RecordScan(ctx context.Context, key string, at time.Time) error {
+	_, err := s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		Item: map[string]dbtypes.AttributeValue{
+			"image":    &dbtypes.AttributeValueMemberS{Value: key},
+			"lastScan": &dbtypes.AttributeValueMemberN{Value: strconv.FormatInt(at.Unix(), 10)},
+		},
+		TableName: aws.String(s.Table),
+	})
+	return err
+}
+
+// SkipRecentlyScannedState returns the images whose scan wasn't requested
+// within the given interval according to the state store. Images whose state
+// can't be read are kept.
+func SkipRecentlyScannedState(
+	ctx context.Context,
+	registry Registry,
+	repository types.Repository,
+	images []types.ImageIdentifier,
+	interval time.Duration,
+) []types.ImageIdentifier {
+	// Setup our logging context for the function.
+	logger := registry.Logger.WithFields(log.Fields{
+		"region":     registry.Region,
+		"repository": *repository.RepositoryName,
+	})
+
+	var remaining []types.ImageIdentifier
+	cutoff := time.Now().Add(-interval)
+	for _, image := range images {
+		scanned, ok, err := registry.State.LastScan(ctx, StateKey(registry, repository, image))
+		if err != nil {
+			registry.Metrics.StateErrors.WithLabelValues("read").Inc()
+			logger.WithFields(log.Fields{
+				"err": err,
+				"image": map[string]string{
+					"digest": *image.ImageDigest,
+					"tag":    ImageTag(image),
+				},
+			}).Warn("failed to read image scan state")
+		} else if ok && scanned.After(cutoff) {
+			logger.WithFields(log.Fields{
+				"image": map[string]string{
+					"digest": *image.ImageDigest,
+					"tag":    ImageTag(image),
+				},
+				"scanned": scanned,
+			}).Debug("image scan requested recently, skipping")
+			registry.Metrics.ScansSkippedRecent.WithLabelValues(registry.Region).Inc()
+			continue
+		}
+		remaining = append(remaining, image)
+	}
+	return remaining
+}
+
+// SkipBeforeWatermark returns the images pushed after the repository's
+// watermark according to the state store, along with the watermark to record
+// once they're reconciled, which is the latest push time among the images.
+// Without a watermark, such as on the first run or once it's lost, every image
+// is kept, as are images whose push time isn't known.
+func SkipBeforeWatermark(
+	ctx context.Context,
+	registry Registry,
+	repository types.Repository,
+	images []types.ImageIdentifier,
+	details map[string]types.ImageDetail,
+) ([]types.ImageIdentifier, time.Time) {
+	// Setup our logging context for the function.
+	logger := registry.Logger.WithFields(log.Fields{
+		"region":     registry.Region,
+		"repository": *repository.RepositoryName,
+	})
+
+	var latest time.Time
+	for _, image := range images {
+		if pushed := PushedAt(details[*image.ImageDigest]); pushed.After(latest) {
+			latest = pushed
+		}
+	}
+
+	watermark, ok, err := registry.State.LastScan(ctx, WatermarkKey(registry, repository))
+	if err != nil {
+		registry.Metrics.StateErrors.WithLabelValues("read").Inc()
+		logger.WithFields(log.Fields{
+			"err": err,
+		}).Warn("failed to read repository watermark, reconciling every image")
+		return images, latest
+	}
+	if !ok {
+		logger.Debug("repository has no watermark, reconciling every image")
+		return images, latest
+	}
+
+	var remaining []types.ImageIdentifier
+	for _, image := range images {
+		pushed := PushedAt(details[*image.ImageDigest])
+		if !pushed.IsZero() && !pushed.After(watermark) {
+			registry.Metrics.ImagesSkippedWatermark.WithLabelValues(registry.Region).Inc()
+			continue
+		}
+		remaining = append(remaining, image)
+	}
+	logger.WithFields(log.Fields{
+		"images":    len(remaining),
+		"watermark": watermark,
+	}).Debug("only reconciling images pushed after the repository watermark")
+	if latest.Before(watermark) {
+		latest = watermark
+	}
+	return remaining, latest
+}
+
+// RecordWatermark records the repository's watermark in the state store, so
+// that the next run only reconciles the images pushed after it.
+func RecordWatermark(
+	ctx context.Context,
+	registry Registry,
+	repository types.Repository,
+	watermark time.Time,
+) {
+	if err := registry.State.RecordScan(ctx, WatermarkKey(registry, repository), watermark); err != nil {
+		registry.Metrics.StateErrors.WithLabelValues("write").Inc()
+		registry.Logger.WithFields(log.Fields{
+			"err":        err,
+			"region":     registry.Region,
+			"repository": *repository.RepositoryName,
+		}).Warn("failed to record repository watermark")
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// TargetedImages returns the repository and image references given on the
+// command line to scan exactly, if any. References are either image digests,
+// such as `sha256:...`, or tags.
+func TargetedImages() (string, []string) {
+	var refs []string
+	for _, ref := range viper.GetStringSlice("image") {
+		if ref = strings.TrimSpace(ref); ref != "" {
+			refs = append(refs, ref)
+		}
+	}
+	return strings.TrimSpace(viper.GetString("repository")), refs
+}
+
+// ResolveImage resolves an image reference of the repository to the image it
+// refers to. Digests are taken as they are, while tags are described to find
+// the digest they currently refer to.
+func ResolveImage(
+	ctx context.Context,
+	registry Registry,
+	repository types.Repository,
+	ref string,
+) (types.ImageIdentifier, error) {
+	if strings.HasPrefix(ref, "sha256:") {
+		return types.ImageIdentifier{ImageDigest: aws.String(ref)}, nil
+	}
+
+	id := types.ImageIdentifier{ImageTag: aws.String(ref)}
+	response, err := registry.Client.DescribeImages(ctx, &ecr.DescribeImagesInput{
+		ImageIds:       []types.ImageIdentifier{id},
+		RegistryId:     registry.ID,
+		RepositoryName: repository.RepositoryName,
+	})
+	if err != nil {
+		return id, err
+	}
+	for _, detail := range response.ImageDetails {
+		if detail.ImageDigest != nil {
+			id.ImageDigest = detail.ImageDigest
+			return id, nil
+		}
+	}
+	return id, &types.ImageNotFoundException{
+		Message: aws.String(fmt.Sprintf("no image tagged %q", ref)),
+	}
+}
+
+// ReconcileTargetedImages requests the scans of exactly the images given on the
+// command line, bypassing the enumeration and filtering of the registry's
+// repositories and images altogether. Images that can't be resolved count as
+// failures, as they were asked for explicitly. An error is only returned if
+// the repository itself doesn't exist.
+func ReconcileTargetedImages(
+	ctx context.Context,
+	registry Registry,
+	pool *WorkerPool,
+) (RunResult, error) {
+	name, refs := TargetedImages()
+	repository := types.Repository{RepositoryName: aws.String(name)}
+
+	// Setup our logging context for the function.
+	logger := registry.Logger.WithFields(log.Fields{
+		"region":     registry.Region,
+		"repository": name,
+	})
+	logger.WithFields(log.Fields{
+		"images": refs,
+	}).Info("scanning targeted images")
+
+	result := RunResult{Repositories: 1}
+	var images []types.ImageIdentifier
+	for _, ref := range refs {
+		image, err := ResolveImage(ctx, registry, repository, ref)
+		if err != nil {
+			var rnfe *types.RepositoryNotFoundException
+			if errors.As(err, &rnfe) {
+				logger.WithFields(log.Fields{
+					"err": err,
+				}).Error("targeted repository doesn't exist")
+				registry.Metrics.RepositoriesNotFound.WithLabelValues(registry.Region).Inc()
+				return result, err
+			}
+			var infe *types.ImageNotFoundException
+			if errors.As(err, &infe) {
+				registry.Metrics.ImagesNotFound.WithLabelValues(registry.Region).Inc()
+			}
+			logger.WithFields(log.Fields{
+				"err":   err,
+				"image": ref,
+			}).Error("failed to resolve targeted image")
+			result.Failures++
+			continue
+		}
+		images = append(images, image)
+	}
+	result.Images = int64(len(images))
+
+	// Request the scans through our pool like any other, and wait for all of
+	// them to finish.
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for _, image := range images {
+		image := image
+		wg.Add(1)
+		pool.Submit(func() {
+			defer wg.Done()
+			reconciled := ReconcileImage(ctx, registry, repository, image)
+			mutex.Lock()
+			result.Merge(reconciled)
+			mutex.Unlock()
+		})
+	}
+	wg.Wait()
+	return result, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of our reconciliation. It defers to the global
+// tracer provider, which does nothing unless tracing is set up.
+var tracer = otel.Tracer("github.com/celestialorb/aws-ecr-scan-operator")
+
+// TracingEnabled reports whether an OTLP exporter is configured through the
+// standard OpenTelemetry environment variables.
+func TracingEnabled() bool {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return false
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" ||
+		os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// SetupTracing installs a tracer provider exporting our spans over OTLP, as
+// configured by the standard OpenTelemetry environment variables. The returned
+// function flushes any remaining spans and must be called before exiting.
+func SetupTracing(ctx context.Context) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Our service name may still be overridden through the environment.
+	res, err := resource.New(
+		ctx,
+		resource.WithAttributes(semconv.ServiceNameKey.String("aws-ecr-scan-operator")),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	log.Info("tracing initialized")
+	return provider.Shutdown, nil
+}
+
+// RecordSpanError marks the span as failed with the given error.
+func RecordSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// ResultAttributes returns the result of a run as span attributes.
+func ResultAttributes(result RunResult) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int64("enqueued", result.Enqueued),
+		attribute.Int64("failures", result.Failures),
+		attribute.Int64("images", result.Images),
+		attribute.Int64("rate_limited", result.RateLimited),
+		attribute.Int64("repositories", result.Repositories),
+		attribute.Int64("requested", result.Requested),
+		attribute.Int64("skipped", result.Skipped),
+		attribute.Int64("unsupported", result.Unsupported),
+	}
+}
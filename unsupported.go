@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/spf13/viper"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+var (
+	unsupportedMutex  sync.Mutex
+	unsupportedImages = map[string]bool{}
+)
+
+// IsUnsupportedImage reports whether a scan request failed as AWS ECR refuses
+// to scan the image at all, such as a Helm chart or another artifact that isn't
+// a container image, or an image of an unsupported OS.
+func IsUnsupportedImage(err error) bool {
+	var uite *types.UnsupportedImageTypeException
+	var ipe *types.InvalidParameterException
+	return errors.As(err, &uite) || errors.As(err, &ipe)
+}
+
+// WarnUnsupported reports whether to warn about the unsupported image with the
+// given state key, which is only the first time it's encountered throughout
+// our lifetime if asked to.
+func WarnUnsupported(key string) bool {
+	if !viper.GetBool("log.unsupported_once") {
+		return true
+	}
+	unsupportedMutex.Lock()
+	defer unsupportedMutex.Unlock()
+	if unsupportedImages[key] {
+		return false
+	}
+	unsupportedImages[key] = true
+	return true
+}
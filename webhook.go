@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// EventRunFinished is the event sent once a run finishes, carrying its summary.
+const EventRunFinished = "RunFinished"
+
+// webhookEvents are the events a webhook can be sent.
+var webhookEvents = map[string]bool{
+	EventFindingsCollected: true,
+	EventRunFinished:       true,
+	EventScanRequested:     true,
+}
+
+// webhook is where run summaries and scan notifications are posted to, if
+// anywhere.
+var webhook *WebhookNotifier
+
+// errWebhookQueueFull is the failure of the payloads dropped as the webhook
+// has fallen too far behind.
+var errWebhookQueueFull = errors.New("webhook queue is full")
+
+// errWebhookClosed is the failure of the payloads dropped as the webhook has
+// been closed on shutdown.
+var errWebhookClosed = errors.New("webhook is closed")
+
+// WebhookRun is the summary of a finished run carried by a webhook payload.
+type WebhookRun struct {
+	Succeeded     bool     `json:"succeeded"`
+	Duration      float64  `json:"duration"`
+	Repositories  int64    `json:"repositories"`
+	Images        int64    `json:"images"`
+	Requested     int64    `json:"requested"`
+	RateLimited   int64    `json:"rateLimited"`
+	Skipped       int64    `json:"skipped"`
+	Unsupported   int64    `json:"unsupported"`
+	Failures      int64    `json:"failures"`
+	OverThreshold int64    `json:"overThreshold"`
+	OverBudget    int64    `json:"overBudget"`
+	DryRun        int64    `json:"dryRun"`
+	Enqueued      int64    `json:"enqueued"`
+	Incomplete    []string `json:"incomplete,omitempty"`
+}
+
+// NewWebhookRun summarizes a finished run for a webhook payload, its duration
+// in seconds.
+func NewWebhookRun(result RunResult, duration time.Duration) WebhookRun {
+	return WebhookRun{
+		Succeeded:     result.Succeeded(),
+		Duration:      duration.Seconds(),
+		Repositories:  result.Repositories,
+		Images:        result.Images,
+		Requested:     result.Requested,
+		RateLimited:   result.RateLimited,
+		Skipped:       result.Skipped,
+		Unsupported:   result.Unsupported,
+		Failures:      result.Failures,
+		OverThreshold: result.OverThreshold,
+		OverBudget:    result.OverBudget,
+		DryRun:        result.DryRun,
+		Enqueued:      result.Enqueued,
+		Incomplete:    result.Incomplete,
+	}
+}
+
+// WebhookPayload is the data each webhook request is rendered from, carrying
+// either the summary of a run or a scan notification depending on its event.
+type WebhookPayload struct {
+	Event string            `json:"event"`
+	Time  time.Time         `json:"time"`
+	Run   *WebhookRun       `json:"run,omitempty"`
+	Scan  *ScanNotification `json:"scan,omitempty"`
+}
+
+// ParseWebhookTemplate parses the template webhook payloads are rendered with,
+// or returns nil if there's none. Besides the usual functions, templates can
+// use `json` to render any value as JSON.
+func ParseWebhookTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return template.New("webhook").Funcs(template.FuncMap{
+		"json": func(value interface{}) (string, error) {
+			encoded, err := json.Marshal(value)
+			return string(encoded), err
+		},
+	}).Parse(text)
+}
+
+// RenderWebhookPayload renders the body of a webhook request from the payload,
+// with the template if there is one and as JSON otherwise.
+func RenderWebhookPayload(tmpl *template.Template, payload WebhookPayload) ([]byte, error) {
+	if tmpl == nil {
+		return json.Marshal(payload)
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, payload); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+// WebhookNotifier posts run summaries and scan notifications to a generic HTTP
+// webhook, retrying failed requests with exponential backoff and jitter. The
+// payloads are posted in the background through a bounded queue, so that a slow
+// or failing webhook never holds up the scans themselves.
+type WebhookNotifier struct {
+	Client      *http.Client
+	Metrics     *Metrics
+	URL         string
+	Headers     map[string]string
+	Template    *template.Template
+	Events      map[string]bool
+	MaxAttempts int
+	BaseDelay   time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	queue  chan WebhookPayload
+	done   chan struct{}
+
+	// mutex guards queueing payloads against closing the queue, which runs
+	// still finishing on shutdown may yet try to.
+	mutex  sync.Mutex
+	closed bool
+}
+
+// Start starts posting the payloads queued, up to the given number of them.
+func (n *WebhookNotifier) Start(size int) {
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	n.queue = make(chan WebhookPayload, size)
+	n.done = make(chan struct{})
+	go func() {
+		defer close(n.done)
+		for payload := range n.queue {
+			n.send(n.ctx, payload)
+		}
+	}()
+}
+
+// Close stops accepting payloads and waits for those queued to be posted, up
+// to the given timeout after which any still queued are abandoned. Payloads
+// queued once closed are dropped.
+func (n *WebhookNotifier) Close(timeout time.Duration) {
+	n.mutex.Lock()
+	if n.closed {
+		n.mutex.Unlock()
+		return
+	}
+	n.closed = true
+	close(n.queue)
+	n.mutex.Unlock()
+
+	select {
+	case <-n.done:
+	case <-time.After(timeout):
+		log.WithFields(log.Fields{
+			"queued": len(n.queue),
+		}).Warn("timed out posting to webhook, abandoning queued payloads")
+		n.cancel()
+		<-n.done
+	}
+	n.cancel()
+}
+
+// SetupWebhook creates the configured webhook, if any, validating its events
+// and template.
+func SetupWebhook(metrics *Metrics) error {
+	url := viper.GetString("notifications.webhook.url")
+	if url == "" {
+		return nil
+	}
+
+	events := map[string]bool{}
+	for _, event := range viper.GetStringSlice("notifications.webhook.events") {
+		if !webhookEvents[event] {
+			return fmt.Errorf("invalid webhook event %q", event)
+		}
+		events[event] = true
+	}
+	tmpl, err := ParseWebhookTemplate(viper.GetString("notifications.webhook.template"))
+	if err != nil {
+		return err
+	}
+	size := viper.GetInt("notifications.webhook.queue_size")
+	if size < 0 {
+		return fmt.Errorf("negative webhook queue size %d", size)
+	}
+
+	webhook = &WebhookNotifier{
+		Client:      &http.Client{Timeout: viper.GetDuration("notifications.webhook.timeout")},
+		Metrics:     metrics,
+		URL:         url,
+		Headers:     viper.GetStringMapString("notifications.webhook.headers"),
+		Template:    tmpl,
+		Events:      events,
+		MaxAttempts: viper.GetInt("notifications.webhook.max_attempts"),
+		BaseDelay:   viper.GetDuration("notifications.webhook.base_delay"),
+	}
+	webhook.Start(size)
+	OnExit(func() {
+		webhook.Close(viper.GetDuration("notifications.webhook.timeout"))
+	})
+	return nil
+}
+
+// NotifyRun queues the summary of a run, if the webhook is sent them.
+func (n *WebhookNotifier) NotifyRun(ctx context.Context, result RunResult, duration time.Duration) {
+	if !n.Events[EventRunFinished] {
+		return
+	}
+	run := NewWebhookRun(result, duration)
+	n.enqueue(WebhookPayload{Event: EventRunFinished, Time: time.Now().UTC(), Run: &run})
+}
+
+// Notify queues the scan notification, if the webhook is sent its event.
+func (n *WebhookNotifier) Notify(ctx context.Context, notification ScanNotification) {
+	if !n.Events[notification.Event] {
+		return
+	}
+	n.enqueue(WebhookPayload{Event: notification.Event, Time: time.Now().UTC(), Scan: &notification})
+}
+
+// enqueue queues the payload to be posted, dropping it rather than waiting if
+// the queue is full or the webhook has been closed.
+func (n *WebhookNotifier) enqueue(payload WebhookPayload) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.closed {
+		n.Metrics.RecordDelivery(ChannelWebhook, errWebhookClosed)
+		log.WithFields(log.Fields{
+			"event": payload.Event,
+		}).Warn("webhook is closed, dropping payload")
+		return
+	}
+	select {
+	case n.queue <- payload:
+	default:
+		n.Metrics.RecordDelivery(ChannelWebhook, errWebhookQueueFull)
+		log.WithFields(log.Fields{
+			"event": payload.Event,
+		}).Error("webhook queue is full, dropping payload")
+	}
+}
+
+// send renders and posts the payload. Failures are logged and counted but
+// never returned, as notifications must not abort reconciliation.
+func (n *WebhookNotifier) send(ctx context.Context, payload WebhookPayload) {
+	body, err := RenderWebhookPayload(n.Template, payload)
+	if err == nil {
+		err = n.post(ctx, payload.Event, body)
+	}
+	n.Metrics.RecordDelivery(ChannelWebhook, err)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":   err,
+			"event": payload.Event,
+		}).Error("failed to post to webhook")
+	}
+}
+
+// post delivers the body to the webhook until it's accepted, it's rejected
+// outright, or the maximum number of attempts is reached. Only network errors,
+// throttling and server-side errors are retried.
+func (n *WebhookNotifier) post(ctx context.Context, event string, body []byte) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retryable bool
+		retryable, err = n.attempt(ctx, body)
+		if err == nil || attempt >= n.MaxAttempts || !retryable {
+			return err
+		}
+
+		// Back off exponentially with full jitter before the next attempt.
+		delay := n.BaseDelay << (attempt - 1)
+		if delay > 0 {
+			delay = time.Duration(rand.Int63n(int64(delay)))
+		}
+		log.WithFields(log.Fields{
+			"attempt": attempt,
+			"delay":   delay,
+			"err":     err,
+			"event":   event,
+		}).Debug("failed to post to webhook, retrying")
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// attempt makes a single request of the webhook, reporting whether it's worth
+// retrying if it fails.
+func (n *WebhookNotifier) attempt(ctx context.Context, body []byte) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range n.Headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := n.Client.Do(request)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		retryable := response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+		return retryable, fmt.Errorf("unexpected response status %q", response.Status)
+	}
+	return false, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// sampleTime is when every sample payload was made.
+var sampleTime = time.Date(2022, 11, 10, 12, 0, 0, 0, time.UTC)
+
+func sampleRunPayload() WebhookPayload {
+	run := NewWebhookRun(RunResult{
+		Reconciled:   1,
+		Repositories: 12,
+		Images:       340,
+		Requested:    25,
+		RateLimited:  3,
+		Skipped:      312,
+	}, 42500*time.Millisecond)
+	return WebhookPayload{Event: EventRunFinished, Time: sampleTime, Run: &run}
+}
+
+func sampleScanPayload() WebhookPayload {
+	return WebhookPayload{
+		Event: EventFindingsCollected,
+		Time:  sampleTime,
+		Scan: &ScanNotification{
+			Event:       EventFindingsCollected,
+			Region:      "us-east-1",
+			Repository:  "my-repository",
+			ImageDigest: "sha256:abc",
+			ImageTag:    "latest",
+			Findings:    map[string]int32{"CRITICAL": 1, "HIGH": 2},
+		},
+	}
+}
+
+func TestRenderWebhookPayload(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		payload  WebhookPayload
+		want     string
+	}{
+		{
+			name:    "run as JSON without a template",
+			payload: sampleRunPayload(),
+			want: `{"event":"RunFinished","time":"2022-11-10T12:00:00Z","run":{"succeeded":true,"duration":42.5,` +
+				`"repositories":12,"images":340,"requested":25,"rateLimited":3,"skipped":312,"unsupported":0,` +
+				`"failures":0,"overThreshold":0,"overBudget":0,"dryRun":0,"enqueued":0}}`,
+		},
+		{
+			name:    "scan as JSON without a template",
+			payload: sampleScanPayload(),
+			want: `{"event":"FindingsCollected","time":"2022-11-10T12:00:00Z","scan":{"event":"FindingsCollected",` +
+				`"region":"us-east-1","repository":"my-repository","imageDigest":"sha256:abc","imageTag":"latest",` +
+				`"findings":{"CRITICAL":1,"HIGH":2}}}`,
+		},
+		{
+			name:     "run fields",
+			template: `{"text": "Requested {{ .Run.Requested }} scans in {{ .Run.Repositories }} repositories"}`,
+			payload:  sampleRunPayload(),
+			want:     `{"text": "Requested 25 scans in 12 repositories"}`,
+		},
+		{
+			name:     "scan fields",
+			template: `{{ .Event }} {{ .Scan.Repository }}:{{ .Scan.ImageTag }} {{ index .Scan.Findings "CRITICAL" }}`,
+			payload:  sampleScanPayload(),
+			want:     `FindingsCollected my-repository:latest 1`,
+		},
+		{
+			name:     "json function",
+			template: `{"repository": {{ json .Scan.Repository }}, "findings": {{ json .Scan.Findings }}}`,
+			payload:  sampleScanPayload(),
+			want:     `{"repository": "my-repository", "findings": {"CRITICAL":1,"HIGH":2}}`,
+		},
+		{
+			name:     "conditionals on the event",
+			template: `{{ if .Run }}run{{ else }}scan of {{ .Scan.ImageDigest }}{{ end }}`,
+			payload:  sampleScanPayload(),
+			want:     `scan of sha256:abc`,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			tmpl, err := ParseWebhookTemplate(test.template)
+			if err != nil {
+				t.Fatalf("ParseWebhookTemplate() error = %v", err)
+			}
+			body, err := RenderWebhookPayload(tmpl, test.payload)
+			if err != nil {
+				t.Fatalf("RenderWebhookPayload() error = %v", err)
+			}
+			if string(body) != test.want {
+				t.Errorf("RenderWebhookPayload() = %s, want %s", body, test.want)
+			}
+		})
+	}
+}
+
+func TestParseWebhookTemplateInvalid(t *testing.T) {
+	if _, err := ParseWebhookTemplate(`{{ .Run.Requested `); err == nil {
+		t.Error("ParseWebhookTemplate() error = nil, want a parse error")
+	}
+}
+
+func TestRenderWebhookPayloadMissingField(t *testing.T) {
+	tmpl, err := ParseWebhookTemplate(`{{ .Run.Requested }}`)
+	if err != nil {
+		t.Fatalf("ParseWebhookTemplate() error = %v", err)
+	}
+	if _, err := RenderWebhookPayload(tmpl, sampleScanPayload()); err == nil {
+		t.Error("RenderWebhookPayload() error = nil, want an error rendering the run of a scan")
+	}
+}
+
+// testWebhook returns a started webhook posting to the URL.
+func testWebhook(url string, size int) *WebhookNotifier {
+	n := &WebhookNotifier{
+		Client:      &http.Client{Timeout: time.Second},
+		Metrics:     NewMetrics(prometheus.NewRegistry()),
+		URL:         url,
+		Headers:     map[string]string{"Authorization": "Bearer example"},
+		Events:      map[string]bool{EventRunFinished: true},
+		MaxAttempts: 3,
+	}
+	n.Start(size)
+	return n
+}
+
+func TestWebhookNotifierPostsInBackground(t *testing.T) {
+	var mutex sync.Mutex
+	var bodies []string
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		attempts++
+		if r.Header.Get("Authorization") != "Bearer example" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		// Fail the first attempt so that it's retried.
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+	}))
+	defer server.Close()
+
+	n := testWebhook(server.URL, 10)
+	n.NotifyRun(context.Background(), RunResult{Reconciled: 1, Requested: 2}, time.Second)
+	n.Close(5 * time.Second)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if attempts != 2 || len(bodies) != 1 {
+		t.Fatalf("webhook got %d attempts and %d bodies, want 2 attempts and 1 body", attempts, len(bodies))
+	}
+	if got := testutil.ToFloat64(n.Metrics.NotificationsSent.WithLabelValues(ChannelWebhook)); got != 1 {
+		t.Errorf("aws_ecr_notifications_sent = %v, want 1", got)
+	}
+}
+
+func TestWebhookNotifierDropsWhenFull(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	// The first payload is being posted and the second fills the queue, so
+	// the third is dropped without waiting for the webhook.
+	n := testWebhook(server.URL, 1)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		n.NotifyRun(context.Background(), RunResult{}, 0)
+		if i == 0 {
+			for len(n.queue) > 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("notifying took %v, want it not to wait for the webhook", elapsed)
+	}
+	close(release)
+	n.Close(5 * time.Second)
+
+	if got := testutil.ToFloat64(n.Metrics.NotificationsErrors.WithLabelValues(ChannelWebhook)); got != 1 {
+		t.Errorf("aws_ecr_notifications_errors = %v, want 1", got)
+	}
+}
+
+func TestWebhookNotifierAfterClose(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("webhook posted to after being closed")
+	}))
+	defer server.Close()
+
+	// Runs still finishing on shutdown notify of them after the webhook has
+	// been closed, which mustn't panic.
+	n := testWebhook(server.URL, 10)
+	n.Events[EventScanRequested] = true
+	n.Close(5 * time.Second)
+	n.Notify(context.Background(), ScanNotification{Event: EventScanRequested})
+	n.NotifyRun(context.Background(), RunResult{}, 0)
+	n.Close(5 * time.Second)
+
+	if got := testutil.ToFloat64(n.Metrics.NotificationsErrors.WithLabelValues(ChannelWebhook)); got != 2 {
+		t.Errorf("aws_ecr_notifications_errors = %v, want 2", got)
+	}
+}
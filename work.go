@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// WorkRoleStandalone both enumerates images and requests their scans.
+	WorkRoleStandalone = "standalone"
+
+	// WorkRoleProducer enumerates images and enqueues a scan task for each onto
+	// the work queue, leaving the scan requests to workers.
+	WorkRoleProducer = "producer"
+
+	// WorkRoleWorker consumes scan tasks from the work queue and requests the
+	// scans, without enumerating anything itself.
+	WorkRoleWorker = "worker"
+
+	// ScanTaskVersion is the version of the scan task message schema.
+	ScanTaskVersion = 1
+)
+
+// ScanTask is the message enqueued for each image whose scan should be
+// requested. Its version only changes on incompatible changes to the schema.
+type ScanTask struct {
+	Version        int       `json:"version"`
+	Profile        string    `json:"profile,omitempty"`
+	Region         string    `json:"region"`
+	RegistryID     string    `json:"registryId,omitempty"`
+	RepositoryName string    `json:"repositoryName"`
+	ImageDigest    string    `json:"imageDigest"`
+	ImageTag       string    `json:"imageTag,omitempty"`
+	EnqueuedAt     time.Time `json:"enqueuedAt"`
+}
+
+// NewScanTask creates the scan task of an image.
+func NewScanTask(registry Registry, repository types.Repository, image types.ImageIdentifier) ScanTask {
+	return ScanTask{
+		Version:        ScanTaskVersion,
+		Profile:        registry.Profile,
+		Region:         registry.Region,
+		RegistryID:     aws.ToString(registry.ID),
+		RepositoryName: *repository.RepositoryName,
+		ImageDigest:    *image.ImageDigest,
+		ImageTag:       aws.ToString(image.ImageTag),
+		EnqueuedAt:     time.Now().UTC(),
+	}
+}
+
+// ParseScanTask parses and validates a scan task message.
+func ParseScanTask(body string) (ScanTask, error) {
+	var task ScanTask
+	if err := json.Unmarshal([]byte(body), &task); err != nil {
+		return task, err
+	}
+	if task.Version != ScanTaskVersion {
+		return task, fmt.Errorf("unsupported scan task version %d", task.Version)
+	}
+	if task.Region == "" || task.RepositoryName == "" || task.ImageDigest == "" {
+		return task, fmt.Errorf("scan task is missing its region, repository name or image digest")
+	}
+	return task, nil
+}
+
+// WorkQueue is the AWS SQS queue scan tasks are distributed through.
+type WorkQueue struct {
+	Client *sqs.Client
+	URL    string
+}
+
+// NewWorkQueue creates a work queue for the given AWS SQS queue URL.
+func NewWorkQueue(cfg aws.Config, url string) *WorkQueue {
+	return &WorkQueue{
+		Client: sqs.NewFromConfig(cfg),
+		URL:    url,
+	}
+}
+
+// Enqueue sends the scan task onto the queue.
+func (q *WorkQueue) Enqueue(ctx context.Context, task ScanTask) error {
+	body, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	_, err = q.Client.SendMessage(ctx, &sqs.SendMessageInput{
+		MessageBody: aws.String(string(body)),
+		QueueUrl:    aws.String(q.URL),
+	})
+	return err
+}
+
+// Worker consumes scan tasks from the work queue and requests their scans. A
+// task is deleted once handled, or otherwise made visible again after the
+// retry delay so that it's retried, by this or any other worker.
+type Worker struct {
+	// Queue is where the scan tasks are consumed from.
+	Queue *WorkQueue
+
+	// Pool is the worker pool all image scan requests are funneled through.
+	Pool *WorkerPool
+
+	// State remembers when scans were last requested, if anywhere.
+	State StateStore
+
+	// Metrics are where the scan tasks handled are recorded.
+	Metrics *Metrics
+
+	// Health tracks our readiness, marked ready once we've received from the
+	// queue, or nil.
+	Health *Health
+
+	limiter    *rate.Limiter
+	mutex      sync.Mutex
+	registries map[string]Registry
+}
+
+// Run consumes scan tasks until the context is done.
+func (w *Worker) Run(ctx context.Context) {
+	w.limiter = NewRateLimiter()
+	w.registries = map[string]Registry{}
+
+	visibility := viper.GetDuration("work.sqs.visibility_timeout")
+	wait := viper.GetDuration("work.sqs.wait_time")
+	log.WithFields(log.Fields{
+		"queue": w.Queue.URL,
+	}).Info("consuming scan tasks")
+
+	for ctx.Err() == nil {
+		response, err := w.Queue.Client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			AttributeNames: []sqstypes.QueueAttributeName{
+				sqstypes.QueueAttributeName(sqstypes.MessageSystemAttributeNameApproximateReceiveCount),
+			},
+			MaxNumberOfMessages: int32(viper.GetInt("work.sqs.max_messages")),
+			QueueUrl:            aws.String(w.Queue.URL),
+			VisibilityTimeout:   int32(visibility.Seconds()),
+			WaitTimeSeconds:     int32(wait.Seconds()),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			w.Metrics.WorkQueueErrors.WithLabelValues("receive").Inc()
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("failed to receive scan tasks, backing off")
+			select {
+			case <-ctx.Done():
+			case <-time.After(viper.GetDuration("work.sqs.retry_delay")):
+			}
+			continue
+		}
+		w.Health.MarkReady("queue")
+
+		// Handle the batch through our pool, waiting for all of it before we
+		// receive any more, having checked the digest list files for changes
+		// once for all of it.
+		digestFiles.Refresh()
+		var wg sync.WaitGroup
+		for _, message := range response.Messages {
+			message := message
+			wg.Add(1)
+			w.Pool.Submit(func() {
+				defer wg.Done()
+				w.handle(ctx, message)
+			})
+		}
+		wg.Wait()
+	}
+}
+
+// handle requests the scan of a single task, deleting it unless it should be
+// retried.
+func (w *Worker) handle(ctx context.Context, message sqstypes.Message) {
+	logger := log.WithFields(log.Fields{
+		"message":  aws.ToString(message.MessageId),
+		"receives": message.Attributes[string(sqstypes.MessageSystemAttributeNameApproximateReceiveCount)],
+	})
+
+	// Tasks we can't understand will never succeed, so there's no point in
+	// retrying them.
+	task, err := ParseScanTask(aws.ToString(message.Body))
+	if err != nil {
+		w.Metrics.WorkQueueErrors.WithLabelValues("parse").Inc()
+		logger.WithFields(log.Fields{
+			"err": err,
+		}).Error("invalid scan task, deleting")
+		w.delete(ctx, logger, message)
+		return
+	}
+
+	registry, err := w.registry(ctx, task)
+	if err != nil {
+		logger.WithFields(log.Fields{
+			"err":    err,
+			"region": task.Region,
+		}).Error("failed to load AWS configuration, retrying scan task")
+		w.retry(ctx, logger, task, message)
+		return
+	}
+
+	image := types.ImageIdentifier{ImageDigest: aws.String(task.ImageDigest)}
+	if task.ImageTag != "" {
+		image.ImageTag = aws.String(task.ImageTag)
+	}
+	result := ReconcileImage(
+		ctx,
+		registry,
+		types.Repository{RepositoryName: aws.String(task.RepositoryName)},
+		image,
+	)
+	if result.Failures > 0 {
+		w.retry(ctx, logger, task, message)
+		return
+	}
+	w.delete(ctx, logger, message)
+}
+
+// registry returns the registry of the task, creating it on first use.
+func (w *Worker) registry(ctx context.Context, task ScanTask) (Registry, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	key := task.Profile + "/" + task.Region + "/" + task.RegistryID
+	if registry, ok := w.registries[key]; ok {
+		return registry, nil
+	}
+	cfg, err := LoadAWSConfig(ctx, AWSConfigOptions(task.Profile, task.Region)...)
+	if err != nil {
+		return Registry{}, err
+	}
+	registry := NewRegistry(cfg, w.Metrics.WithProfile(task.Profile), w.State, w.limiter)
+	registry.Profile = task.Profile
+	if task.RegistryID != "" {
+		registry.ID = aws.String(task.RegistryID)
+	}
+	w.registries[key] = registry
+	return registry, nil
+}
+
+// retry makes the task visible again after the retry delay.
+func (w *Worker) retry(ctx context.Context, logger *log.Entry, task ScanTask, message sqstypes.Message) {
+	w.Metrics.WithProfile(task.Profile).WorkTasksRetried.WithLabelValues(task.Region).Inc()
+	_, err := w.Queue.Client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(w.Queue.URL),
+		ReceiptHandle:     message.ReceiptHandle,
+		VisibilityTimeout: int32(viper.GetDuration("work.sqs.retry_delay").Seconds()),
+	})
+	if err != nil {
+		w.Metrics.WorkQueueErrors.WithLabelValues("retry").Inc()
+		logger.WithFields(log.Fields{
+			"err": err,
+		}).Warn("failed to change scan task visibility, retrying after the visibility timeout")
+	}
+}
+
+// delete removes the handled task from the queue.
+func (w *Worker) delete(ctx context.Context, logger *log.Entry, message sqstypes.Message) {
+	_, err := w.Queue.Client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(w.Queue.URL),
+		ReceiptHandle: message.ReceiptHandle,
+	})
+	if err != nil {
+		w.Metrics.WorkQueueErrors.WithLabelValues("delete").Inc()
+		logger.WithFields(log.Fields{
+			"err": err,
+		}).Warn("failed to delete scan task, it will be handled again")
+	}
+}